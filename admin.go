@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+	"tailscale.com/ipn"
+)
+
+var (
+	errRoutesNotStarted = errors.New("routes are not running yet")
+	errRouteExists      = errors.New("route name or hostname already in use")
+	errRouteNotFound    = errors.New("route not found")
+)
+
+// adminDrainTimeout is how long a drain request waits for in-flight requests
+// unless it asks for another timeout
+const adminDrainTimeout = 30 * time.Second
+
+// adminMaxBody caps the size of admin API request bodies
+const adminMaxBody = 64 * 1024
+
+// redacted replaces secrets in the configuration dump
+const redacted = "[redacted]"
+
+// secretRouteOptions are the route options whose values are secrets
+var secretRouteOptions = []string{"auth-key", "oidc-client-secret", "oidc-cookie-secret"}
+
+// urlRouteOptions are the route options holding URLs, or lists of them,
+// that may carry credentials
+var urlRouteOptions = []string{"upstreams", "forward-auth-url"}
+
+// validateAdminListen only accepts loopback addresses; remote access goes
+// through the admin tailnet node, where ACLs apply.
+func validateAdminListen(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid admin listen address %q: %w", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("admin listen address %q must be a loopback address; use --admin-hostname for remote access", addr)
+}
+
+// adminRoute describes a route in admin API responses
+type adminRoute struct {
+	Name           string     `json:"name"`
+	Backend        string     `json:"backend"`
+	FQDN           string     `json:"fqdn"`
+	IPv4           string     `json:"ipv4,omitempty"`
+	IPv6           string     `json:"ipv6,omitempty"`
+	NodeState      string     `json:"node_state"`
+	NodeStateSince *time.Time `json:"node_state_since,omitempty"`
+	Status         string     `json:"status"`
+	Healthy        bool       `json:"healthy"`
+	InFlight       int64      `json:"in_flight"`
+	UptimeSeconds  int64      `json:"uptime_seconds"`
+	Dynamic        bool       `json:"dynamic"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// addRouteRequest is the body of a request adding a route
+type addRouteRequest struct {
+	Name    string            `json:"name"`
+	Backend string            `json:"backend"`
+	Options map[string]string `json:"options"` // Same keys as --route-option
+}
+
+func (s *server) adminRoute(h *routeHandle) adminRoute {
+	r := adminRoute{
+		Name:          h.name,
+		Backend:       redactURL(h.backend),
+		FQDN:          h.route.NodeHostname() + "." + s.config.TailscaleDomain,
+		NodeState:     ipn.NoState.String(),
+		Status:        h.gate.Status(),
+		InFlight:      h.gate.InFlight(),
+		UptimeSeconds: int64(time.Since(h.started).Seconds()),
+		Dynamic:       h.dynamic,
+	}
+
+	h.mu.Lock()
+	if h.fqdn != "" {
+		r.FQDN = h.fqdn
+	}
+	if h.ip4.IsValid() {
+		r.IPv4 = h.ip4.String()
+	}
+	if h.ip6.IsValid() {
+		r.IPv6 = h.ip6.String()
+	}
+	if h.err != nil {
+		r.Error = h.err.Error()
+	}
+	h.mu.Unlock()
+
+	if st, ok := s.nodeStates.get(h.name); ok {
+		r.NodeState = st.State.String()
+		since := st.Since
+		r.NodeStateSince = &since
+		r.Healthy = st.Healthy() && r.Status == routeStatusActive
+	}
+	return r
+}
+
+// newAdminHandler builds the admin API
+func (s *server) newAdminHandler() http.Handler {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+
+	e.GET("/routes", func(c echo.Context) error {
+		routes := []adminRoute{}
+		for _, h := range s.routes.list() {
+			routes = append(routes, s.adminRoute(h))
+		}
+		return c.JSON(http.StatusOK, routes)
+	})
+	e.POST("/routes", s.adminAddRoute)
+	e.GET("/routes/:name", func(c echo.Context) error {
+		h, err := s.adminRouteHandle(c)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, s.adminRoute(h))
+	})
+	e.DELETE("/routes/:name", func(c echo.Context) error {
+		err := s.removeRoute(c.Request().Context(), c.Param("name"))
+		if errors.Is(err, errRouteNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+	e.POST("/routes/:name/pause", func(c echo.Context) error {
+		return s.adminSetStatus(c, routeStatusPaused)
+	})
+	e.POST("/routes/:name/resume", func(c echo.Context) error {
+		return s.adminSetStatus(c, routeStatusActive)
+	})
+	e.POST("/routes/:name/drain", s.adminDrainRoute)
+	e.GET("/config", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, maskConfig(s.config))
+	})
+	return e
+}
+
+func (s *server) adminRouteHandle(c echo.Context) (*routeHandle, error) {
+	h := s.routes.get(c.Param("name"))
+	if h == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, errRouteNotFound.Error())
+	}
+	return h, nil
+}
+
+func (s *server) adminAddRoute(c echo.Context) error {
+	var req addRouteRequest
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, adminMaxBody)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "request body too large")
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body: "+err.Error())
+	}
+	name := strings.ToLower(strings.TrimSpace(req.Name))
+	if err := validateNodeHostname(name); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid route name: "+err.Error())
+	}
+	if err := validateBackendURL(req.Backend); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	route, err := s.config.RouteConfig(name)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	for key, value := range req.Options {
+		if err := applyRouteOption(route, key, value); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+	if err := route.validateAuth(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err := validateUpstreams(req.Backend, route.Upstreams); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// The admin node owns its hostname and state directory.
+	if admin := s.config.Admin.Hostname; admin != "" && (name == admin || route.NodeHostname() == admin) {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("%s is the admin node hostname", admin))
+	}
+
+	// Like the routes checked at startup, a route minting its auth keys
+	// must not log in with tags the OAuth client cannot create.
+	if s.tsClient != nil && s.config.KeyProvider.Provider == authKeyProviderTailscale && route.AuthKey == (AuthKeyConfig{}) {
+		if err := checkMintableTags(c.Request().Context(), s.tsClient, [][]string{route.Tags}); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+
+	h, err := s.launchRoute(route, req.Backend, true)
+	switch {
+	case errors.Is(err, errRouteExists):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case err != nil:
+		return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+	}
+	log.Info().Str("route", name).Str("backend", redactURL(req.Backend)).Msg("Route added through the admin API")
+	return c.JSON(http.StatusCreated, s.adminRoute(h))
+}
+
+func (s *server) adminSetStatus(c echo.Context, status string) error {
+	h, err := s.adminRouteHandle(c)
+	if err != nil {
+		return err
+	}
+	if h.gate.Status() == routeStatusStopped {
+		return echo.NewHTTPError(http.StatusConflict, "route is stopped")
+	}
+	h.gate.setStatus(status)
+	log.Info().Str("route", h.name).Str("status", status).Msg("Route status changed through the admin API")
+	return c.JSON(http.StatusOK, s.adminRoute(h))
+}
+
+// adminDrainRoute stops admitting requests and answers once the in-flight
+// ones have finished or the timeout (?timeout=, default 30s) has passed.
+func (s *server) adminDrainRoute(c echo.Context) error {
+	h, err := s.adminRouteHandle(c)
+	if err != nil {
+		return err
+	}
+	if h.gate.Status() == routeStatusStopped {
+		return echo.NewHTTPError(http.StatusConflict, "route is stopped")
+	}
+	timeout := adminDrainTimeout
+	if v := c.QueryParam("timeout"); v != "" {
+		timeout, err = time.ParseDuration(v)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid timeout: "+err.Error())
+		}
+	}
+
+	log.Info().Str("route", h.name).Int64("in_flight", h.gate.InFlight()).Msg("Draining route through the admin API")
+	ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+	defer cancel()
+	if remaining := h.gate.drain(ctx); remaining > 0 {
+		log.Warn().Str("route", h.name).Int64("in_flight", remaining).Msg("Route drain timed out")
+	}
+	return c.JSON(http.StatusOK, s.adminRoute(h))
+}
+
+// runAdmin serves the admin API on the loopback listener and the admin
+// tailnet node, whichever are configured, until ctx is done.
+func (s *server) runAdmin(ctx context.Context) error {
+	handler := s.newAdminHandler()
+	g, gctx := errgroup.WithContext(ctx)
+
+	if addr := s.config.Admin.Listen; addr != "" {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on admin address %s: %w", addr, err)
+		}
+		log.Info().Str("addr", ln.Addr().String()).Msg("Admin API listening")
+		g.Go(func() error { return serveAdmin(gctx, ln, handler) })
+	}
+
+	if hostname := s.config.Admin.Hostname; hostname != "" {
+		for name := range s.config.Routes {
+			if rc, err := s.config.RouteConfig(name); name == hostname || err == nil && rc.NodeHostname() == hostname {
+				return fmt.Errorf("admin hostname %s is also used by route %s", hostname, name)
+			}
+		}
+		route, err := s.config.RouteConfig(hostname)
+		if err != nil {
+			return err
+		}
+		tsServer, err := s.startTailscaleInstance(ctx, route)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to start admin node %s: %w", hostname, err)
+		}
+		defer tsServer.Close()
+		ln, err := tsServer.Listen("tcp", ":80")
+		if err != nil {
+			return fmt.Errorf("failed to listen on admin node %s: %w", hostname, err)
+		}
+		log.Info().Str("fqdn", route.NodeHostname()+"."+s.config.TailscaleDomain).Msg("Admin API listening on the tailnet")
+		g.Go(func() error { return serveAdmin(gctx, ln, handler) })
+	}
+
+	return g.Wait()
+}
+
+func serveAdmin(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("admin API stopped: %w", err)
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+		return nil
+	}
+}
+
+// maskConfig returns a copy of the effective configuration with secrets and
+// backend credentials redacted
+func maskConfig(c *Config) Config {
+	m := *c
+	m.OAuth.ClientSecret = redactString(m.OAuth.ClientSecret)
+	m.OIDC.ClientSecret = redactString(m.OIDC.ClientSecret)
+	m.OIDC.CookieSecret = redactString(m.OIDC.CookieSecret)
+	m.KeyProvider.HeadscaleAPIKey = redactString(m.KeyProvider.HeadscaleAPIKey)
+	m.AuthKey.Key = redactString(m.AuthKey.Key)
+	m.State.EncryptionKey = redactString(m.State.EncryptionKey)
+	m.Pyroscope.AuthToken = redactString(m.Pyroscope.AuthToken)
+	m.Pyroscope.BasicAuthPass = redactString(m.Pyroscope.BasicAuthPass)
+	m.OpenTelemetry.Headers = redactValues(m.OpenTelemetry.Headers)
+	m.Pyroscope.HTTPHeaders = redactValues(m.Pyroscope.HTTPHeaders)
+	m.ForwardAuth.URL = redactURL(m.ForwardAuth.URL)
+
+	m.Routes = make(map[string]string, len(c.Routes))
+	for name, backend := range c.Routes {
+		m.Routes[name] = redactURL(backend)
+	}
+	m.RouteOptions = make(map[string]map[string]string, len(c.RouteOptions))
+	for name, opts := range c.RouteOptions {
+		masked := make(map[string]string, len(opts))
+		for key, value := range opts {
+			switch {
+			case slices.Contains(secretRouteOptions, key):
+				value = redactString(value)
+			case slices.Contains(urlRouteOptions, key):
+				urls := splitRouteOptionList(value)
+				for i, u := range urls {
+					urls[i] = redactURL(u)
+				}
+				value = strings.Join(urls, routeOptionListSeparator)
+			}
+			masked[key] = value
+		}
+		m.RouteOptions[name] = masked
+	}
+	return m
+}
+
+func redactString(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func redactValues(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		out[k] = redactString(v)
+	}
+	return out
+}
+
+// redactURL hides the password of a URL with credentials
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// Auth key providers
+const (
+	authKeyProviderTailscale = "tailscale" // Mint keys with the Tailscale API (OAuth client)
+	authKeyProviderHeadscale = "headscale" // Mint pre-auth keys with the Headscale API
+	authKeyProviderStatic    = "static"    // Use a pre-generated key
+)
+
+// headscaleKeyExpiry is how long minted Headscale pre-auth keys stay valid;
+// they are used right away.
+const headscaleKeyExpiry = time.Hour
+
+func validateAuthKeyProvider(provider string) error {
+	switch provider {
+	case authKeyProviderTailscale, authKeyProviderHeadscale, authKeyProviderStatic:
+		return nil
+	}
+	return fmt.Errorf("invalid auth key provider %q (valid: tailscale, headscale, static)", provider)
+}
+
+// authKeyRequest describes the node an auth key is needed for
+type authKeyRequest struct {
+	RouteName string
+	Tags      []string
+	Ephemeral bool
+}
+
+// authKeyProvider supplies the auth keys new route nodes log in with
+type authKeyProvider interface {
+	createAuthKey(ctx context.Context, req authKeyRequest) (string, error)
+}
+
+// newAuthKeyProvider builds the configured provider. The Tailscale API client
+// is only used by the tailscale provider and may be nil, in which case keys
+// fail to be minted only when a route without state has to log in.
+func newAuthKeyProvider(cfg KeyProviderConfig, authKey AuthKeyConfig, tsClient *tailscale.Client, controlURL string) (authKeyProvider, error) {
+	switch cfg.Provider {
+	case authKeyProviderTailscale, "":
+		return &tailscaleKeyProvider{client: tsClient}, nil
+	case authKeyProviderHeadscale:
+		apiURL := cfg.HeadscaleURL
+		if apiURL == "" {
+			apiURL = controlURL
+		}
+		if apiURL == "" || cfg.HeadscaleAPIKey == "" || cfg.HeadscaleUser == "" {
+			return nil, errors.New("the headscale auth key provider requires --headscale-api-key, --headscale-user and --control-url or --headscale-url")
+		}
+		return &headscaleKeyProvider{
+			url:    strings.TrimSuffix(apiURL, "/"),
+			apiKey: cfg.HeadscaleAPIKey,
+			user:   cfg.HeadscaleUser,
+			client: &http.Client{Timeout: 30 * time.Second},
+		}, nil
+	case authKeyProviderStatic:
+		return staticKeyProvider(authKey), nil
+	}
+	return nil, validateAuthKeyProvider(cfg.Provider)
+}
+
+// tailscaleKeyProvider mints single-use, pre-authorized keys through the
+// Tailscale API
+type tailscaleKeyProvider struct {
+	client *tailscale.Client
+}
+
+func (p *tailscaleKeyProvider) createAuthKey(ctx context.Context, req authKeyRequest) (string, error) {
+	if p.client == nil {
+		return "", errors.New("no auth key available: set --auth-key or --auth-key-file, or --oauth-client-id and --oauth-client-secret to mint one")
+	}
+	return createNewAuthKey(ctx, p.client, req.Tags, req.RouteName, req.Ephemeral)
+}
+
+// headscaleKeyProvider mints single-use pre-auth keys through the Headscale
+// API
+type headscaleKeyProvider struct {
+	url    string
+	apiKey string
+	user   string
+	client *http.Client
+}
+
+func (p *headscaleKeyProvider) createAuthKey(ctx context.Context, req authKeyRequest) (string, error) {
+	log.Info().Str("route", req.RouteName).Bool("ephemeral", req.Ephemeral).Msg("Creating Headscale pre-auth key")
+
+	body, err := json.Marshal(map[string]any{
+		"user":       p.user,
+		"reusable":   false,
+		"ephemeral":  req.Ephemeral,
+		"expiration": time.Now().Add(headscaleKeyExpiry).UTC().Format(time.RFC3339),
+		"aclTags":    req.Tags,
+	})
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url+"/api/v1/preauthkey", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+p.apiKey)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Headscale pre-auth key: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to create Headscale pre-auth key: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+
+	var result struct {
+		PreAuthKey struct {
+			Key string `json:"key"`
+		} `json:"preAuthKey"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return "", fmt.Errorf("failed to parse Headscale pre-auth key response: %w", err)
+	}
+	if result.PreAuthKey.Key == "" {
+		return "", errors.New("headscale returned an empty pre-auth key")
+	}
+	log.Info().Str("route", req.RouteName).Msg("Headscale pre-auth key created successfully")
+	return result.PreAuthKey.Key, nil
+}
+
+// staticKeyProvider hands out a pre-generated key, reading it from its file
+// on every login so rotated keys are picked up. Its tags and ephemeral
+// setting were chosen when it was generated.
+type staticKeyProvider AuthKeyConfig
+
+func (p staticKeyProvider) createAuthKey(ctx context.Context, req authKeyRequest) (string, error) {
+	key := p.Key
+	if p.File != "" {
+		data, err := os.ReadFile(p.File)
+		if err != nil {
+			return "", fmt.Errorf("failed to read auth key file: %w", err)
+		}
+		key = strings.TrimSpace(string(data))
+	}
+	if key == "" {
+		return "", fmt.Errorf("no auth key configured for route %s (--auth-key, --auth-key-file or the auth-key route option)", req.RouteName)
+	}
+	log.Info().Str("route", req.RouteName).Msg("Using pre-generated auth key")
+	return key, nil
+}
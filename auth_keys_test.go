@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeadscaleKeyProvider(t *testing.T) {
+	var body map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/preauthkey", r.URL.Path)
+		assert.Equal(t, "Bearer hs-api-key", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		_ = json.NewEncoder(w).Encode(map[string]any{"preAuthKey": map[string]any{"key": "hskey-auth-test"}})
+	}))
+	defer srv.Close()
+
+	keys, err := newAuthKeyProvider(KeyProviderConfig{
+		Provider:        authKeyProviderHeadscale,
+		HeadscaleAPIKey: "hs-api-key",
+		HeadscaleUser:   "1",
+	}, AuthKeyConfig{}, nil, srv.URL+"/")
+	require.NoError(t, err)
+
+	key, err := keys.createAuthKey(context.Background(), authKeyRequest{RouteName: "app", Tags: []string{"tag:gw"}, Ephemeral: true})
+	require.NoError(t, err)
+	assert.Equal(t, "hskey-auth-test", key)
+	assert.Equal(t, "1", body["user"])
+	assert.Equal(t, false, body["reusable"])
+	assert.Equal(t, true, body["ephemeral"])
+	assert.Equal(t, []any{"tag:gw"}, body["aclTags"])
+	expiration, err := time.Parse(time.RFC3339, body["expiration"].(string))
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(headscaleKeyExpiry), expiration, time.Minute)
+}
+
+func TestHeadscaleKeyProvider_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "user not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	keys, err := newAuthKeyProvider(KeyProviderConfig{
+		Provider:        authKeyProviderHeadscale,
+		HeadscaleURL:    srv.URL,
+		HeadscaleAPIKey: "hs-api-key",
+		HeadscaleUser:   "missing",
+	}, AuthKeyConfig{}, nil, "")
+	require.NoError(t, err)
+	_, err = keys.createAuthKey(context.Background(), authKeyRequest{RouteName: "app", Tags: []string{"tag:gw"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "user not found")
+}
+
+func TestNewAuthKeyProvider(t *testing.T) {
+	keys, err := newAuthKeyProvider(KeyProviderConfig{Provider: authKeyProviderStatic}, AuthKeyConfig{Key: "tskey-auth-static"}, nil, "")
+	require.NoError(t, err)
+	key, err := keys.createAuthKey(context.Background(), authKeyRequest{RouteName: "app"})
+	require.NoError(t, err)
+	assert.Equal(t, "tskey-auth-static", key)
+
+	for _, cfg := range []KeyProviderConfig{
+		{Provider: authKeyProviderHeadscale, HeadscaleAPIKey: "k"},
+		{Provider: "vault"},
+	} {
+		_, err := newAuthKeyProvider(cfg, AuthKeyConfig{}, nil, "")
+		assert.Error(t, err, cfg.Provider)
+	}
+}
+
+func TestNewAuthKeyProvider_NoCredentials(t *testing.T) {
+	// Without credentials the gateway still starts; keys only fail to be
+	// created when a route without state needs one.
+	for _, provider := range []string{authKeyProviderTailscale, authKeyProviderStatic} {
+		keys, err := newAuthKeyProvider(KeyProviderConfig{Provider: provider}, AuthKeyConfig{}, nil, "")
+		require.NoError(t, err, provider)
+		_, err = keys.createAuthKey(context.Background(), authKeyRequest{RouteName: "app"})
+		assert.Error(t, err, provider)
+	}
+}
+
+func TestStaticKeyProvider_File(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "authkey")
+	require.NoError(t, os.WriteFile(file, []byte("tskey-auth-one\n"), 0o600))
+
+	keys := staticKeyProvider(AuthKeyConfig{File: file})
+	key, err := keys.createAuthKey(context.Background(), authKeyRequest{RouteName: "app"})
+	require.NoError(t, err)
+	assert.Equal(t, "tskey-auth-one", key)
+
+	// The file is read on every login, so a rotated key is picked up.
+	require.NoError(t, os.WriteFile(file, []byte("tskey-auth-two"), 0o600))
+	key, err = keys.createAuthKey(context.Background(), authKeyRequest{RouteName: "app"})
+	require.NoError(t, err)
+	assert.Equal(t, "tskey-auth-two", key)
+
+	_, err = staticKeyProvider(AuthKeyConfig{File: filepath.Join(t.TempDir(), "missing")}).createAuthKey(context.Background(), authKeyRequest{RouteName: "app"})
+	assert.Error(t, err)
+}
+
+func TestServer_AuthKeys(t *testing.T) {
+	minted := &headscaleKeyProvider{}
+	s := &server{keys: minted}
+
+	assert.Same(t, minted, s.authKeys(&RouteConfig{Name: "app"}))
+	assert.Equal(t, staticKeyProvider(AuthKeyConfig{Key: "tskey-auth-route"}),
+		s.authKeys(&RouteConfig{Name: "app", AuthKey: AuthKeyConfig{Key: "tskey-auth-route"}}))
+	assert.IsType(t, &tailscaleKeyProvider{}, (&server{}).authKeys(&RouteConfig{Name: "app"}))
+}
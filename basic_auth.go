@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// credentialReloadInterval bounds how often credential files are checked
+// for changes.
+const credentialReloadInterval = 2 * time.Second
+
+// reloadingFile keeps the parsed content of a file and re-parses it when the
+// file changes on disk. A broken update keeps the previous content.
+type reloadingFile[T any] struct {
+	path  string
+	parse func([]byte) (T, error)
+
+	mu      sync.Mutex
+	value   T
+	modTime time.Time
+	checked time.Time
+}
+
+func newReloadingFile[T any](path string, parse func([]byte) (T, error)) (*reloadingFile[T], error) {
+	f := &reloadingFile[T]{path: path, parse: parse}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.load(info.ModTime()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *reloadingFile[T]) get() T {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if time.Since(f.checked) < credentialReloadInterval {
+		return f.value
+	}
+	f.checked = time.Now()
+	info, err := os.Stat(f.path)
+	if err != nil || info.ModTime().Equal(f.modTime) {
+		return f.value
+	}
+	if err := f.load(info.ModTime()); err != nil {
+		log.Warn().Err(err).Str("file", f.path).Msg("Failed to reload credentials; keeping the previous ones")
+	} else {
+		log.Info().Str("file", f.path).Msg("Reloaded credentials")
+	}
+	return f.value
+}
+
+func (f *reloadingFile[T]) load(modTime time.Time) error {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	value, err := f.parse(data)
+	if err != nil {
+		return err
+	}
+	f.value = value
+	f.modTime = modTime
+	f.checked = time.Now()
+	return nil
+}
+
+// credentialLines yields the non-empty, non-comment lines of a credential
+// file with their line numbers. Errors never include line content.
+func credentialLines(data []byte, fn func(n int, line string) error) error {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(n, line); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+// parseHtpasswd reads user:hash lines; hashes must be bcrypt or argon2id
+func parseHtpasswd(data []byte) (map[string]string, error) {
+	users := make(map[string]string)
+	err := credentialLines(data, func(n int, line string) error {
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return fmt.Errorf("htpasswd line %d: expected user:hash", n)
+		}
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "$argon2id$") {
+			return fmt.Errorf("htpasswd line %d: unsupported hash (use bcrypt or argon2id)", n)
+		}
+		users[user] = hash
+		return nil
+	})
+	return users, err
+}
+
+// apiKey is one entry of the API keys file. Only the SHA-256 of the key is
+// kept in memory.
+type apiKey struct {
+	name string
+	sum  [sha256.Size]byte
+}
+
+// parseAPIKeys reads name:key lines, where key is either the key itself or
+// sha256:<hex> of it.
+func parseAPIKeys(data []byte) ([]apiKey, error) {
+	var keys []apiKey
+	err := credentialLines(data, func(n int, line string) error {
+		name, key, ok := strings.Cut(line, ":")
+		if !ok || name == "" || key == "" {
+			return fmt.Errorf("API keys line %d: expected name:key", n)
+		}
+		k := apiKey{name: name}
+		if hexSum, hashed := strings.CutPrefix(key, "sha256:"); hashed {
+			b, err := hex.DecodeString(hexSum)
+			if err != nil || len(b) != sha256.Size {
+				return fmt.Errorf("API keys line %d: invalid sha256 digest", n)
+			}
+			copy(k.sum[:], b)
+		} else {
+			k.sum = sha256.Sum256([]byte(key))
+		}
+		keys = append(keys, k)
+		return nil
+	})
+	return keys, err
+}
+
+// verifyPasswordHash checks a password against a bcrypt or argon2id hash
+func verifyPasswordHash(hash, password string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return verifyArgon2id(hash, password)
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// verifyArgon2id checks a PHC formatted hash:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+func verifyArgon2id(hash, password string) bool {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return false
+	}
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false
+	}
+	got := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// credentialAuth protects a route with basic auth and/or API keys
+type credentialAuth struct {
+	routeName   string
+	realm       string
+	exemptPaths []string
+	exemptTags  []string
+	identify    func(c echo.Context) *peerIdentity
+
+	users *reloadingFile[map[string]string]
+	keys  *reloadingFile[[]apiKey]
+
+	// Successful password checks, keyed by a digest of user, password and
+	// hash, so the slow hash only runs once per credential.
+	verifiedMu sync.Mutex
+	verified   map[[sha256.Size]byte]struct{}
+}
+
+func newCredentialAuth(rs *RouteServer) (*credentialAuth, error) {
+	cfg := rs.route.Auth
+	a := &credentialAuth{
+		routeName:   rs.RouteName,
+		realm:       cfg.Realm,
+		exemptPaths: cfg.ExemptPaths,
+		exemptTags:  cfg.ExemptTags,
+		identify:    rs.identify,
+		verified:    make(map[[sha256.Size]byte]struct{}),
+	}
+	if cfg.HtpasswdFile != "" {
+		users, err := newReloadingFile(cfg.HtpasswdFile, parseHtpasswd)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load htpasswd file: %w", err)
+		}
+		a.users = users
+	}
+	if cfg.APIKeysFile != "" {
+		keys, err := newReloadingFile(cfg.APIKeysFile, parseAPIKeys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load API keys file: %w", err)
+		}
+		a.keys = keys
+	}
+	if a.users == nil && a.keys == nil {
+		return nil, errors.New("no credential file configured")
+	}
+	return a, nil
+}
+
+func (a *credentialAuth) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		// Only the gateway may set the identity, exempt requests included.
+		req.Header.Del(headerAuthUser)
+		req.Header.Del(headerAuthEmail)
+		req.Header.Del(headerAuthGroups)
+		if a.exempt(c) {
+			return next(c)
+		}
+
+		if name, ok := a.authenticate(req); ok {
+			req.Header.Set(headerAuthUser, name)
+			return next(c)
+		}
+
+		log.Debug().Str("route", a.routeName).Str("remote", req.RemoteAddr).Str("path", req.URL.Path).Msg("Request without valid credentials")
+		if a.users != nil {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, fmt.Sprintf("Basic realm=%q", a.realm))
+		} else {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, fmt.Sprintf("Bearer realm=%q", a.realm))
+		}
+		return echo.ErrUnauthorized
+	}
+}
+
+func (a *credentialAuth) exempt(c echo.Context) bool {
+	path := cleanRequestPath(c.Request().URL.Path)
+	for _, p := range a.exemptPaths {
+		if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	if len(a.exemptTags) > 0 {
+		id := a.identify(c)
+		return slices.ContainsFunc(id.Tags, func(t string) bool { return slices.Contains(a.exemptTags, t) })
+	}
+	return false
+}
+
+// cleanRequestPath resolves dot-segments the way the backend would, so
+// "/public/../admin" is matched as "/admin". A trailing slash is kept.
+func cleanRequestPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// authenticate checks the request credentials and removes them so they are
+// never forwarded to the backend. It returns the user or key name.
+func (a *credentialAuth) authenticate(req *http.Request) (string, bool) {
+	defer func() {
+		req.Header.Del(echo.HeaderAuthorization)
+		req.Header.Del("X-API-Key")
+	}()
+
+	if a.users != nil {
+		if user, pass, ok := req.BasicAuth(); ok && a.checkPassword(user, pass) {
+			return user, true
+		}
+	}
+	if a.keys != nil {
+		key := req.Header.Get("X-API-Key")
+		if bearer, ok := strings.CutPrefix(req.Header.Get(echo.HeaderAuthorization), "Bearer "); ok {
+			key = strings.TrimSpace(bearer)
+		}
+		if key != "" {
+			if name, ok := a.checkKey(key); ok {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (a *credentialAuth) checkPassword(user, pass string) bool {
+	hash, ok := a.users.get()[user]
+	if !ok {
+		return false
+	}
+	digest := sha256.Sum256([]byte(user + "\x00" + pass + "\x00" + hash))
+
+	a.verifiedMu.Lock()
+	_, cached := a.verified[digest]
+	a.verifiedMu.Unlock()
+	if cached {
+		return true
+	}
+	if !verifyPasswordHash(hash, pass) {
+		return false
+	}
+
+	a.verifiedMu.Lock()
+	if len(a.verified) >= 1024 {
+		clear(a.verified)
+	}
+	a.verified[digest] = struct{}{}
+	a.verifiedMu.Unlock()
+	return true
+}
+
+func (a *credentialAuth) checkKey(key string) (string, bool) {
+	sum := sha256.Sum256([]byte(key))
+	name, found := "", false
+	// Compare against every key so timing does not reveal the position.
+	for _, k := range a.keys.get() {
+		if subtle.ConstantTimeCompare(sum[:], k.sum[:]) == 1 && !found {
+			name, found = k.name, true
+		}
+	}
+	return name, found
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+func argon2idHash(password string) string {
+	salt := []byte("0123456789abcdef")
+	sum := argon2.IDKey([]byte(password), salt, 1, 8*1024, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, 8*1024, 1, 1,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(sum))
+}
+
+func writeCredentialFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "creds")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func newCredentialEcho(t *testing.T, cfg AuthConfig) (*echo.Echo, *credentialAuth) {
+	t.Helper()
+	cfg.Realm = "test"
+	rs := &RouteServer{
+		RouteName: "test",
+		route:     &RouteConfig{Auth: cfg},
+		whoIsFn: func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+			if remoteAddr == "100.64.0.9:1234" {
+				return &apitype.WhoIsResponse{Node: &tailcfg.Node{StableID: "n9", Tags: []string{"tag:monitoring"}}}, nil
+			}
+			return &apitype.WhoIsResponse{UserProfile: &tailcfg.UserProfile{LoginName: "bob@example.com"}}, nil
+		},
+	}
+	auth, err := newCredentialAuth(rs)
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.Use(auth.middleware)
+	e.Any("/*", func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Request().Header.Get(headerAuthUser)+"|"+c.Request().Header.Get(echo.HeaderAuthorization))
+	})
+	return e, auth
+}
+
+func doAuthRequest(e *echo.Echo, path string, setup func(r *http.Request)) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if setup != nil {
+		setup(req)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCredentialAuth_BasicAuth(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	htpasswd := writeCredentialFile(t, "# users\nalice:"+string(bcryptHash)+"\nbob:"+argon2idHash("hunter2")+"\n")
+	e, _ := newCredentialEcho(t, AuthConfig{HtpasswdFile: htpasswd})
+
+	rec := doAuthRequest(e, "/", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Basic realm="test"`, rec.Header().Get(echo.HeaderWWWAuthenticate))
+
+	rec = doAuthRequest(e, "/", func(r *http.Request) { r.SetBasicAuth("alice", "wrong") })
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	for user, pass := range map[string]string{"alice": "s3cret", "bob": "hunter2"} {
+		rec = doAuthRequest(e, "/", func(r *http.Request) { r.SetBasicAuth(user, pass) })
+		assert.Equal(t, http.StatusOK, rec.Code)
+		// The credentials are not forwarded to the backend.
+		assert.Equal(t, user+"|", rec.Body.String())
+	}
+}
+
+func TestCredentialAuth_APIKeys(t *testing.T) {
+	sum := sha256.Sum256([]byte("hashed-key"))
+	keys := writeCredentialFile(t, "ci:plain-key\nwebhook:sha256:"+hex.EncodeToString(sum[:])+"\n")
+	e, _ := newCredentialEcho(t, AuthConfig{APIKeysFile: keys})
+
+	rec := doAuthRequest(e, "/", func(r *http.Request) { r.Header.Set("Authorization", "Bearer plain-key") })
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ci|", rec.Body.String())
+
+	rec = doAuthRequest(e, "/", func(r *http.Request) { r.Header.Set("X-API-Key", "hashed-key") })
+	assert.Equal(t, "webhook|", rec.Body.String())
+
+	rec = doAuthRequest(e, "/", func(r *http.Request) { r.Header.Set("X-API-Key", "nope") })
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, `Bearer realm="test"`, rec.Header().Get(echo.HeaderWWWAuthenticate))
+}
+
+func TestCredentialAuth_Exemptions(t *testing.T) {
+	keys := writeCredentialFile(t, "ci:plain-key\n")
+	e, _ := newCredentialEcho(t, AuthConfig{
+		APIKeysFile: keys,
+		ExemptPaths: []string{"/healthz", "/hooks/"},
+		ExemptTags:  []string{"tag:monitoring"},
+	})
+
+	assert.Equal(t, http.StatusOK, doAuthRequest(e, "/healthz", nil).Code)
+	assert.Equal(t, http.StatusOK, doAuthRequest(e, "/hooks/github", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, doAuthRequest(e, "/healthz/deep", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, doAuthRequest(e, "/hooks/../admin", nil).Code)
+	assert.Equal(t, http.StatusUnauthorized, doAuthRequest(e, "/hooks/%2e%2e/admin", nil).Code)
+
+	// Exempt requests cannot claim an identity.
+	rec := doAuthRequest(e, "/healthz", func(r *http.Request) { r.Header.Set(headerAuthUser, "root") })
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "|", rec.Body.String())
+
+	rec = doAuthRequest(e, "/", func(r *http.Request) { r.RemoteAddr = "100.64.0.9:1234" })
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = doAuthRequest(e, "/", func(r *http.Request) { r.RemoteAddr = "100.64.0.2:1234" })
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestCredentialAuth_StripsForgedIdentity(t *testing.T) {
+	keys := writeCredentialFile(t, "ci:plain-key\n")
+	_, auth := newCredentialEcho(t, AuthConfig{APIKeysFile: keys, ExemptPaths: []string{"/healthz"}})
+	e := echo.New()
+	e.Use(auth.middleware)
+	e.Any("/*", func(c echo.Context) error {
+		h := c.Request().Header
+		return c.String(http.StatusOK, h.Get(headerAuthUser)+"|"+h.Get(headerAuthEmail)+"|"+h.Get(headerAuthGroups))
+	})
+	forge := func(r *http.Request) {
+		r.Header.Set(headerAuthEmail, "root@example.com")
+		r.Header.Set(headerAuthGroups, "admins")
+	}
+
+	rec := doAuthRequest(e, "/", func(r *http.Request) {
+		forge(r)
+		r.Header.Set("X-API-Key", "plain-key")
+	})
+	assert.Equal(t, "ci||", rec.Body.String())
+	rec = doAuthRequest(e, "/healthz", forge)
+	assert.Equal(t, "||", rec.Body.String())
+}
+
+func TestCredentialAuth_Reload(t *testing.T) {
+	keys := writeCredentialFile(t, "ci:old-key\n")
+	e, auth := newCredentialEcho(t, AuthConfig{APIKeysFile: keys})
+	withKey := func(key string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("X-API-Key", key) }
+	}
+	assert.Equal(t, http.StatusOK, doAuthRequest(e, "/", withKey("old-key")).Code)
+
+	forceReload := func(content string) {
+		require.NoError(t, os.WriteFile(keys, []byte(content), 0o600))
+		future := time.Now().Add(time.Minute)
+		require.NoError(t, os.Chtimes(keys, future, future))
+		auth.keys.mu.Lock()
+		auth.keys.checked = time.Time{}
+		auth.keys.mu.Unlock()
+	}
+
+	forceReload("ci:new-key\n")
+	assert.Equal(t, http.StatusUnauthorized, doAuthRequest(e, "/", withKey("old-key")).Code)
+	assert.Equal(t, http.StatusOK, doAuthRequest(e, "/", withKey("new-key")).Code)
+
+	// A broken file keeps the previous keys.
+	forceReload("garbage\n")
+	assert.Equal(t, http.StatusOK, doAuthRequest(e, "/", withKey("new-key")).Code)
+}
+
+func TestParseHtpasswd_Errors(t *testing.T) {
+	_, err := parseHtpasswd([]byte("alice:{SHA}secret-looking-hash\n"))
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret-looking-hash")
+
+	_, err = parseHtpasswd([]byte("no-colon\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// cacheEntry is a stored backend response. A Vary marker entry only carries
+// VaryNames and points to the variants stored under their own keys.
+type cacheEntry struct {
+	Key        string
+	Status     int
+	Header     http.Header
+	Body       []byte
+	Stored     time.Time
+	Expires    time.Time     // End of the freshness lifetime
+	StaleWhile time.Duration // stale-while-revalidate window after Expires
+	VaryNames  []string
+	VaryMarker bool
+}
+
+func (e *cacheEntry) size() int64 {
+	n := int64(len(e.Key) + len(e.Body))
+	for k, vs := range e.Header {
+		n += int64(len(k))
+		for _, v := range vs {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+// memoryCache is a size-bounded LRU of cache entries
+type memoryCache struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	ll      list.List // front is most recently used
+	items   map[string]*list.Element
+}
+
+func newMemoryCache(maxSize int64) *memoryCache {
+	return &memoryCache{
+		maxSize: maxSize,
+		items:   make(map[string]*list.Element),
+	}
+}
+
+func (m *memoryCache) get(key string) *cacheEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[key]
+	if !ok {
+		return nil
+	}
+	m.ll.MoveToFront(el)
+	return el.Value.(*cacheEntry)
+}
+
+func (m *memoryCache) set(e *cacheEntry) {
+	size := e.size()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	// An entry too large to keep still replaces the previous one.
+	if el, ok := m.items[e.Key]; ok {
+		m.removeElement(el)
+	}
+	if size > m.maxSize {
+		return
+	}
+	m.items[e.Key] = m.ll.PushFront(e)
+	m.size += size
+	for m.size > m.maxSize {
+		m.removeElement(m.ll.Back())
+	}
+}
+
+func (m *memoryCache) delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[key]; ok {
+		m.removeElement(el)
+	}
+}
+
+// purge removes every entry whose key starts with prefix
+func (m *memoryCache) purge(prefix string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for key, el := range m.items {
+		if strings.HasPrefix(key, prefix) {
+			m.removeElement(el)
+			n++
+		}
+	}
+	return n
+}
+
+func (m *memoryCache) removeElement(el *list.Element) {
+	e := m.ll.Remove(el).(*cacheEntry)
+	delete(m.items, e.Key)
+	m.size -= e.size()
+}
+
+// diskCache persists entries as one gob file per key, evicting the least
+// recently used files once maxSize is exceeded.
+type diskCache struct {
+	dir     string
+	maxSize int64
+
+	mu   sync.Mutex
+	size int64
+}
+
+func newDiskCache(dir string, maxSize int64) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir %s: %w", dir, err)
+	}
+	d := &diskCache{dir: dir, maxSize: maxSize}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache dir %s: %w", dir, err)
+	}
+	for _, de := range entries {
+		if info, err := de.Info(); err == nil && !de.IsDir() {
+			d.size += info.Size()
+		}
+	}
+	return d, nil
+}
+
+func (d *diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:]))
+}
+
+func (d *diskCache) get(key string) *cacheEntry {
+	p := d.path(key)
+	e, err := readCacheFile(p)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Debug().Err(err).Str("file", p).Msg("Failed to read cache entry")
+		}
+		return nil
+	}
+	if e.Key != key {
+		return nil
+	}
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+	return e
+}
+
+func (d *diskCache) set(e *cacheEntry) {
+	p := d.path(e.Key)
+	tmp, err := os.CreateTemp(d.dir, ".tmp-*")
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to create cache file")
+		return
+	}
+	err = gob.NewEncoder(tmp).Encode(e)
+	info, statErr := tmp.Stat()
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = statErr
+	}
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to write cache file")
+		os.Remove(tmp.Name())
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if old, err := os.Stat(p); err == nil {
+		d.size -= old.Size()
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		log.Debug().Err(err).Msg("Failed to store cache file")
+		os.Remove(tmp.Name())
+		return
+	}
+	d.size += info.Size()
+	if d.size > d.maxSize {
+		d.evictLocked()
+	}
+}
+
+func (d *diskCache) delete(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.removeLocked(d.path(key))
+}
+
+// purge removes every entry whose key starts with prefix
+func (d *diskCache) purge(prefix string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, de := range entries {
+		p := filepath.Join(d.dir, de.Name())
+		if prefix == "" {
+			d.removeLocked(p)
+			n++
+			continue
+		}
+		if e, err := readCacheFile(p); err == nil && strings.HasPrefix(e.Key, prefix) {
+			d.removeLocked(p)
+			n++
+		}
+	}
+	return n
+}
+
+// evictLocked removes the least recently used files until the store is
+// back under 90% of its size cap.
+func (d *diskCache) evictLocked() {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return
+	}
+	type file struct {
+		path  string
+		mtime time.Time
+	}
+	files := make([]file, 0, len(entries))
+	for _, de := range entries {
+		if info, err := de.Info(); err == nil && !de.IsDir() {
+			files = append(files, file{filepath.Join(d.dir, de.Name()), info.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mtime.Before(files[j].mtime) })
+
+	target := d.maxSize * 9 / 10
+	for _, f := range files {
+		if d.size <= target {
+			break
+		}
+		d.removeLocked(f.path)
+	}
+}
+
+func (d *diskCache) removeLocked(p string) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return
+	}
+	if err := os.Remove(p); err == nil {
+		d.size -= info.Size()
+	}
+}
+
+func readCacheFile(p string) (*cacheEntry, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var e cacheEntry
+	if err := gob.NewDecoder(f).Decode(&e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
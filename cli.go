@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"net/http"
 	"strings"
 	"time"
 
@@ -15,17 +16,16 @@ func NewCLI(action cli.ActionFunc) *cli.Command {
 		Description: "Tailscale HTTPS Load Balancer - Routes requests based on Host header to configured backends",
 		Flags: []cli.Flag{
 			// Basic configuration
-			&cli.StringFlag{
+			&cli.StringSliceFlag{
 				Name:    "tailscale-tag",
-				Usage:   "Tailscale tag to assign to gateway nodes (must exist in Tailscale ACLs)",
-				Value:   "tsgw",
+				Usage:   "Tailscale tags to assign to gateway nodes (repeatable or comma-separated, must exist in Tailscale ACLs)",
+				Value:   []string{"tsgw"},
 				Sources: cli.EnvVars("TSGW_TAILSCALE_TAG"),
-				Action: func(ctx context.Context, cmd *cli.Command, value string) error {
-					if strings.HasPrefix(value, "tag:") {
-						return nil
+				Action: func(ctx context.Context, cmd *cli.Command, values []string) error {
+					if err := validateTags(normalizeTags(values)); err != nil {
+						return cli.Exit(err.Error(), 1)
 					}
-					// Prepend "tag:" if not already present
-					return cmd.Set("tailscale-tag", "tag:"+value)
+					return nil
 				},
 			},
 			&cli.StringFlag{
@@ -47,18 +47,27 @@ func NewCLI(action cli.ActionFunc) *cli.Command {
 				Sources: cli.EnvVars("TSGW_HTTPS_PORT"),
 			},
 
+			&cli.StringFlag{
+				Name:    "control-url",
+				Usage:   "Coordination server URL for route nodes, e.g. a Headscale server (default: Tailscale)",
+				Sources: cli.EnvVars("TSGW_CONTROL_URL"),
+			},
+
 			// OAuth configuration
 			&cli.StringFlag{
-				Name:     "oauth-client-id",
-				Usage:    "OAuth client ID",
-				Required: true,
-				Sources:  cli.EnvVars("TSGW_OAUTH_CLIENT_ID"),
+				Name:    "oauth-client-id",
+				Usage:   "OAuth client ID (required by the tailscale auth key provider)",
+				Sources: cli.EnvVars("TSGW_OAUTH_CLIENT_ID"),
 			},
 			&cli.StringFlag{
-				Name:     "oauth-client-secret",
-				Usage:    "OAuth client secret",
-				Required: true,
-				Sources:  cli.EnvVars("TSGW_OAUTH_CLIENT_SECRET"),
+				Name:    "oauth-client-secret",
+				Usage:   "OAuth client secret (required by the tailscale auth key provider unless --oauth-id-token-file is set)",
+				Sources: cli.EnvVars("TSGW_OAUTH_CLIENT_SECRET"),
+			},
+			&cli.StringFlag{
+				Name:    "oauth-id-token-file",
+				Usage:   "File containing an OIDC ID token (e.g. a projected Kubernetes service account token) exchanged for API tokens through workload identity federation, instead of --oauth-client-secret",
+				Sources: cli.EnvVars("TSGW_OAUTH_ID_TOKEN_FILE"),
 			},
 			&cli.StringFlag{
 				Name:    "oauth-issuer",
@@ -67,6 +76,45 @@ func NewCLI(action cli.ActionFunc) *cli.Command {
 				Sources: cli.EnvVars("TSGW_OAUTH_ISSUER"),
 			},
 
+			// Auth key provisioning
+			&cli.StringFlag{
+				Name:    "auth-key-provider",
+				Usage:   "How auth keys for new route nodes are minted when no --auth-key is set: tailscale, headscale or static (never mint)",
+				Value:   authKeyProviderTailscale,
+				Sources: cli.EnvVars("TSGW_AUTH_KEY_PROVIDER"),
+				Action: func(ctx context.Context, cmd *cli.Command, value string) error {
+					if err := validateAuthKeyProvider(value); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:    "auth-key",
+				Usage:   "Pre-generated auth key for new route nodes, used instead of minting one (must be reusable for several routes)",
+				Sources: cli.EnvVars("TSGW_AUTH_KEY"),
+			},
+			&cli.StringFlag{
+				Name:    "auth-key-file",
+				Usage:   "File containing a pre-generated auth key, read whenever a route node logs in",
+				Sources: cli.EnvVars("TSGW_AUTH_KEY_FILE"),
+			},
+			&cli.StringFlag{
+				Name:    "headscale-url",
+				Usage:   "Headscale API URL (headscale provider, default: --control-url)",
+				Sources: cli.EnvVars("TSGW_HEADSCALE_URL"),
+			},
+			&cli.StringFlag{
+				Name:    "headscale-api-key",
+				Usage:   "Headscale API key (headscale provider)",
+				Sources: cli.EnvVars("TSGW_HEADSCALE_API_KEY"),
+			},
+			&cli.StringFlag{
+				Name:    "headscale-user",
+				Usage:   "Headscale user owning the minted pre-auth keys (headscale provider)",
+				Sources: cli.EnvVars("TSGW_HEADSCALE_USER"),
+			},
+
 			// Routes (repeating flag)
 			&cli.StringSliceFlag{
 				Name:     "route",
@@ -100,6 +148,54 @@ func NewCLI(action cli.ActionFunc) *cli.Command {
 				},
 			},
 
+			&cli.StringSliceFlag{
+				Name:    "route-option",
+				Usage:   "Per-route override in format 'route:key=value', where key is the name of a per-route flag such as retry-attempts; separate list values with '|' (can be specified multiple times)",
+				Sources: cli.EnvVars("TSGW_ROUTE_OPTIONS"),
+				Action: func(ctx context.Context, cmd *cli.Command, values []string) error {
+					for _, v := range values {
+						_, key, value, err := parseRouteOption(v)
+						if err != nil {
+							return cli.Exit(err.Error(), 1)
+						}
+						if err := applyRouteOption(&RouteConfig{}, key, value); err != nil {
+							return cli.Exit(err.Error(), 1)
+						}
+					}
+					return nil
+				},
+			},
+
+			// Admin API
+			&cli.StringFlag{
+				Name:    "admin-listen",
+				Usage:   "Loopback address serving the admin API, e.g. 127.0.0.1:9090 (empty disables it)",
+				Sources: cli.EnvVars("TSGW_ADMIN_LISTEN"),
+				Action: func(ctx context.Context, cmd *cli.Command, value string) error {
+					if value == "" {
+						return nil
+					}
+					if err := validateAdminListen(value); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:    "admin-hostname",
+				Usage:   "Hostname of a dedicated tailnet node serving the admin API on port 80, restricted by tailnet ACLs (empty disables it)",
+				Sources: cli.EnvVars("TSGW_ADMIN_HOSTNAME"),
+				Action: func(ctx context.Context, cmd *cli.Command, value string) error {
+					if value == "" {
+						return nil
+					}
+					if err := validateNodeHostname(value); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+
 			// Other options
 			&cli.StringFlag{
 				Name:    "log-level",
@@ -118,6 +214,66 @@ func NewCLI(action cli.ActionFunc) *cli.Command {
 				Usage:   "Skip TLS certificate verification for HTTPS backends",
 				Sources: cli.EnvVars("TSGW_SKIP_TLS_VERIFY"),
 			},
+			&cli.StringFlag{
+				Name:    "dial-via",
+				Usage:   "How backends are reached: host (host network) or tailnet (the route's Tailscale node, for backends on the tailnet)",
+				Value:   dialViaHost,
+				Sources: cli.EnvVars("TSGW_DIAL_VIA"),
+				Action: func(ctx context.Context, cmd *cli.Command, value string) error {
+					if err := validateDialVia(value); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:    "client-cert-auth",
+				Usage:   "Client certificate authentication on the tailnet HTTPS listener: none, request or require",
+				Value:   clientCertAuthNone,
+				Sources: cli.EnvVars("TSGW_CLIENT_CERT_AUTH"),
+				Action: func(ctx context.Context, cmd *cli.Command, value string) error {
+					if err := validateClientCertAuth(value); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:    "client-ca-file",
+				Usage:   "PEM bundle of CAs client certificates are verified against",
+				Sources: cli.EnvVars("TSGW_CLIENT_CA_FILE"),
+			},
+			&cli.StringFlag{
+				Name:    "upstream-ca-file",
+				Usage:   "PEM bundle of CAs trusted for HTTPS backends instead of the system roots",
+				Sources: cli.EnvVars("TSGW_UPSTREAM_CA_FILE"),
+			},
+			&cli.StringFlag{
+				Name:    "upstream-server-name",
+				Usage:   "Server name sent as SNI and verified on HTTPS backends (default: backend host)",
+				Sources: cli.EnvVars("TSGW_UPSTREAM_SERVER_NAME"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "upstream-pin-sha256",
+				Usage:   "Accepted SHA-256 fingerprints (hex) of the backend certificate (repeatable)",
+				Sources: cli.EnvVars("TSGW_UPSTREAM_PIN_SHA256"),
+				Action: func(ctx context.Context, cmd *cli.Command, values []string) error {
+					if err := validateCertPins(values); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:    "upstream-client-cert",
+				Usage:   "PEM client certificate presented to HTTPS backends (mTLS)",
+				Sources: cli.EnvVars("TSGW_UPSTREAM_CLIENT_CERT"),
+			},
+			&cli.StringFlag{
+				Name:    "upstream-client-key",
+				Usage:   "PEM private key of the upstream client certificate",
+				Sources: cli.EnvVars("TSGW_UPSTREAM_CLIENT_KEY"),
+			},
 			&cli.StringFlag{
 				Name:    "tsnet-dir",
 				Usage:   "Directory for Tailscale machine files (default: ./tsnet)",
@@ -129,6 +285,90 @@ func NewCLI(action cli.ActionFunc) *cli.Command {
 				Usage:   "Force cleanup of existing Tailscale state files before starting",
 				Sources: cli.EnvVars("TSGW_FORCE_CLEANUP"),
 			},
+			&cli.StringFlag{
+				Name:    "state-store",
+				Usage:   "Where route machine state is kept: dir, mem (ephemeral nodes only), kube or encrypted",
+				Value:   stateStoreDir,
+				Sources: cli.EnvVars("TSGW_STATE_STORE"),
+				Action: func(ctx context.Context, cmd *cli.Command, value string) error {
+					if err := validateStateStore(value); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:    "state-kube-secret-prefix",
+				Usage:   "Prefix of the Kubernetes Secret holding each route's state (kube store)",
+				Value:   "tsgw-",
+				Sources: cli.EnvVars("TSGW_STATE_KUBE_SECRET_PREFIX"),
+			},
+			&cli.StringFlag{
+				Name:    "state-encryption-key",
+				Usage:   "Secret used to encrypt route state at rest (encrypted store)",
+				Sources: cli.EnvVars("TSGW_STATE_ENCRYPTION_KEY"),
+			},
+			&cli.BoolFlag{
+				Name:    "ephemeral",
+				Usage:   "Register route nodes as ephemeral and delete them from the tailnet on shutdown",
+				Sources: cli.EnvVars("TSGW_EPHEMERAL"),
+			},
+			&cli.BoolFlag{
+				Name:    "accept-routes",
+				Usage:   "Let route nodes use subnet routes advertised by other nodes",
+				Sources: cli.EnvVars("TSGW_ACCEPT_ROUTES"),
+			},
+			&cli.BoolFlag{
+				Name:    "accept-dns",
+				Usage:   "Let route nodes use the tailnet DNS configuration",
+				Sources: cli.EnvVars("TSGW_ACCEPT_DNS"),
+			},
+			&cli.DurationFlag{
+				Name:    "key-expiry-warning",
+				Usage:   "Warn when a route node key expires within this time",
+				Value:   7 * 24 * time.Hour,
+				Sources: cli.EnvVars("TSGW_KEY_EXPIRY_WARNING"),
+			},
+			&cli.DurationFlag{
+				Name:    "key-expiry-renew-before",
+				Usage:   "Re-authenticate a route node with a new auth key when its key expires within this time (0 disables)",
+				Sources: cli.EnvVars("TSGW_KEY_EXPIRY_RENEW_BEFORE"),
+			},
+			&cli.BoolFlag{
+				Name:    "disable-key-expiry",
+				Usage:   "Disable key expiry for route devices through the Tailscale API",
+				Sources: cli.EnvVars("TSGW_DISABLE_KEY_EXPIRY"),
+			},
+			&cli.StringFlag{
+				Name:    "stale-device-policy",
+				Usage:   "What to do with offline devices holding a route hostname before a new node logs in: off, warn, delete or fail",
+				Value:   staleDevicePolicyWarn,
+				Sources: cli.EnvVars("TSGW_STALE_DEVICE_POLICY"),
+				Action: func(ctx context.Context, cmd *cli.Command, value string) error {
+					if err := validateStaleDevicePolicy(value); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+			&cli.DurationFlag{
+				Name:    "stale-device-offline-after",
+				Usage:   "How long a device must have been offline to count as stale",
+				Value:   time.Minute,
+				Sources: cli.EnvVars("TSGW_STALE_DEVICE_OFFLINE_AFTER"),
+			},
+			&cli.StringFlag{
+				Name:    "device-reconcile",
+				Usage:   "Periodically compare each running route's device with its tags and key expiry setting: off, warn (log drift) or apply (log and correct drift)",
+				Value:   reconcilePolicyWarn,
+				Sources: cli.EnvVars("TSGW_DEVICE_RECONCILE"),
+				Action: func(ctx context.Context, cmd *cli.Command, value string) error {
+					if err := validateReconcilePolicy(value); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
 
 			// Timeouts
 			&cli.DurationFlag{
@@ -144,6 +384,333 @@ func NewCLI(action cli.ActionFunc) *cli.Command {
 				Sources: cli.EnvVars("TSGW_REQUEST_TIMEOUT"),
 			},
 
+			// Retries (per-route overridable)
+			&cli.IntFlag{
+				Name:    "retry-attempts",
+				Usage:   "Extra attempts for failed backend requests (0 disables retries)",
+				Value:   0,
+				Sources: cli.EnvVars("TSGW_RETRY_ATTEMPTS"),
+			},
+			&cli.DurationFlag{
+				Name:    "retry-try-timeout",
+				Usage:   "Time to wait for backend response headers on each attempt (0 disables)",
+				Value:   0,
+				Sources: cli.EnvVars("TSGW_RETRY_TRY_TIMEOUT"),
+			},
+			&cli.DurationFlag{
+				Name:    "retry-backoff",
+				Usage:   "Base delay between attempts, doubled on every retry",
+				Value:   100 * time.Millisecond,
+				Sources: cli.EnvVars("TSGW_RETRY_BACKOFF"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "retry-on",
+				Usage:   "Failure classes to retry (repeatable): connect, reset, timeout, 502, 503, 504",
+				Value:   []string{"connect", "reset", "502", "503", "504"},
+				Sources: cli.EnvVars("TSGW_RETRY_ON"),
+				Action: func(ctx context.Context, cmd *cli.Command, values []string) error {
+					if err := validateRetryClasses(values); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+			&cli.Int64Flag{
+				Name:    "retry-max-body",
+				Usage:   "Largest request body (bytes) buffered so it can be replayed on retry",
+				Value:   64 * 1024,
+				Sources: cli.EnvVars("TSGW_RETRY_MAX_BODY"),
+			},
+
+			// Rate limiting (per-route overridable)
+			&cli.Float64Flag{
+				Name:    "rate-limit",
+				Usage:   "Sustained requests per second allowed per client (0 disables)",
+				Value:   0,
+				Sources: cli.EnvVars("TSGW_RATE_LIMIT"),
+			},
+			&cli.IntFlag{
+				Name:    "rate-limit-burst",
+				Usage:   "Requests a client may burst above the rate (0 uses the rate rounded up)",
+				Value:   0,
+				Sources: cli.EnvVars("TSGW_RATE_LIMIT_BURST"),
+			},
+			&cli.StringFlag{
+				Name:    "rate-limit-key",
+				Usage:   "What identifies a client for rate limiting: node, user, tag or ip",
+				Value:   "node",
+				Sources: cli.EnvVars("TSGW_RATE_LIMIT_KEY"),
+				Action: func(ctx context.Context, cmd *cli.Command, value string) error {
+					if err := validateIdentityKey(value); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+
+			// Backend concurrency (per-route overridable)
+			&cli.IntFlag{
+				Name:    "max-in-flight",
+				Usage:   "Maximum concurrent requests proxied to a backend (0 is unlimited)",
+				Value:   0,
+				Sources: cli.EnvVars("TSGW_MAX_IN_FLIGHT"),
+			},
+			&cli.IntFlag{
+				Name:    "max-queue",
+				Usage:   "Requests allowed to wait for a free backend slot before rejecting with 503",
+				Value:   100,
+				Sources: cli.EnvVars("TSGW_MAX_QUEUE"),
+			},
+			&cli.DurationFlag{
+				Name:    "queue-timeout",
+				Usage:   "Longest a request waits for a free backend slot (0 waits until the client gives up)",
+				Value:   30 * time.Second,
+				Sources: cli.EnvVars("TSGW_QUEUE_TIMEOUT"),
+			},
+
+			// Response caching (per-route overridable)
+			&cli.BoolFlag{
+				Name:    "cache",
+				Usage:   "Cache GET/HEAD responses according to their Cache-Control headers",
+				Sources: cli.EnvVars("TSGW_CACHE"),
+			},
+			&cli.Int64Flag{
+				Name:    "cache-max-size",
+				Usage:   "Memory budget of the response cache per route, in bytes",
+				Value:   64 << 20,
+				Sources: cli.EnvVars("TSGW_CACHE_MAX_SIZE"),
+			},
+			&cli.Int64Flag{
+				Name:    "cache-max-object-size",
+				Usage:   "Largest response body stored in the cache, in bytes",
+				Value:   8 << 20,
+				Sources: cli.EnvVars("TSGW_CACHE_MAX_OBJECT_SIZE"),
+			},
+			&cli.Int64Flag{
+				Name:    "cache-disk-max-size",
+				Usage:   "Size cap of the on-disk cache under tsnet-dir per route, in bytes (0 disables)",
+				Value:   0,
+				Sources: cli.EnvVars("TSGW_CACHE_DISK_MAX_SIZE"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "cache-purge-allow",
+				Usage:   "User logins, tags or node names allowed to call the cache purge endpoint (repeatable)",
+				Sources: cli.EnvVars("TSGW_CACHE_PURGE_ALLOW"),
+			},
+
+			// Response compression (per-route overridable)
+			&cli.BoolFlag{
+				Name:    "compression",
+				Usage:   "Compress responses according to the client Accept-Encoding",
+				Sources: cli.EnvVars("TSGW_COMPRESSION"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "compression-encodings",
+				Usage:   "Content codings offered, in preference order (repeatable): br, zstd, gzip",
+				Value:   []string{"br", "zstd", "gzip"},
+				Sources: cli.EnvVars("TSGW_COMPRESSION_ENCODINGS"),
+				Action: func(ctx context.Context, cmd *cli.Command, values []string) error {
+					if err := validateCompressionEncodings(values); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:    "compression-level",
+				Usage:   "Compression level: fastest, default or best",
+				Value:   "default",
+				Sources: cli.EnvVars("TSGW_COMPRESSION_LEVEL"),
+				Action: func(ctx context.Context, cmd *cli.Command, value string) error {
+					if err := validateCompressionLevel(value); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+			&cli.IntFlag{
+				Name:    "compression-min-size",
+				Usage:   "Smallest response body worth compressing, in bytes",
+				Value:   1024,
+				Sources: cli.EnvVars("TSGW_COMPRESSION_MIN_SIZE"),
+			},
+			&cli.StringSliceFlag{
+				Name:  "compression-content-types",
+				Usage: "Compressible media types (repeatable); entries ending in '/' match a prefix",
+				Value: []string{
+					"text/", "application/json", "application/javascript", "application/xml",
+					"application/xhtml+xml", "application/rss+xml", "application/atom+xml",
+					"application/manifest+json", "application/wasm", "image/svg+xml",
+				},
+				Sources: cli.EnvVars("TSGW_COMPRESSION_CONTENT_TYPES"),
+			},
+
+			// OIDC authentication (per-route overridable)
+			&cli.StringFlag{
+				Name:    "oidc-issuer",
+				Usage:   "OpenID Connect issuer URL; enables login in front of routes",
+				Sources: cli.EnvVars("TSGW_OIDC_ISSUER"),
+			},
+			&cli.StringFlag{
+				Name:    "oidc-client-id",
+				Usage:   "OIDC client ID",
+				Sources: cli.EnvVars("TSGW_OIDC_CLIENT_ID"),
+			},
+			&cli.StringFlag{
+				Name:    "oidc-client-secret",
+				Usage:   "OIDC client secret",
+				Sources: cli.EnvVars("TSGW_OIDC_CLIENT_SECRET"),
+			},
+			&cli.StringFlag{
+				Name:    "oidc-redirect-url",
+				Usage:   "OIDC callback URL registered with the provider (default: https://<host>/.tsgw/oidc/callback)",
+				Sources: cli.EnvVars("TSGW_OIDC_REDIRECT_URL"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "oidc-scopes",
+				Usage:   "OIDC scopes to request (repeatable)",
+				Value:   []string{"openid", "email", "profile"},
+				Sources: cli.EnvVars("TSGW_OIDC_SCOPES"),
+			},
+			&cli.StringFlag{
+				Name:    "oidc-cookie-secret",
+				Usage:   "Secret encrypting the OIDC session cookie (default: random, sessions end on restart)",
+				Sources: cli.EnvVars("TSGW_OIDC_COOKIE_SECRET"),
+			},
+			&cli.DurationFlag{
+				Name:    "oidc-session-ttl",
+				Usage:   "Lifetime of an OIDC session before logging in again",
+				Value:   12 * time.Hour,
+				Sources: cli.EnvVars("TSGW_OIDC_SESSION_TTL"),
+				Action: func(ctx context.Context, cmd *cli.Command, value time.Duration) error {
+					if err := validateSessionTTL(value); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+			&cli.StringFlag{
+				Name:    "oidc-groups-claim",
+				Usage:   "ID token claim holding the user groups",
+				Value:   "groups",
+				Sources: cli.EnvVars("TSGW_OIDC_GROUPS_CLAIM"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "oidc-allowed-groups",
+				Usage:   "Groups allowed to log in (repeatable; default: any authenticated user)",
+				Sources: cli.EnvVars("TSGW_OIDC_ALLOWED_GROUPS"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "oidc-required-claims",
+				Usage:   "claim=value pairs every login must carry (repeatable)",
+				Sources: cli.EnvVars("TSGW_OIDC_REQUIRED_CLAIMS"),
+				Action: func(ctx context.Context, cmd *cli.Command, values []string) error {
+					if err := validateRequiredClaims(values); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+
+			// Basic auth and API keys (per-route overridable)
+			&cli.StringFlag{
+				Name:    "basic-auth-file",
+				Usage:   "htpasswd file (bcrypt or argon2id hashes) enabling basic auth; reloaded on change",
+				Sources: cli.EnvVars("TSGW_BASIC_AUTH_FILE"),
+			},
+			&cli.StringFlag{
+				Name:    "api-keys-file",
+				Usage:   "File of name:key lines accepted as bearer tokens or X-API-Key; reloaded on change",
+				Sources: cli.EnvVars("TSGW_API_KEYS_FILE"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "auth-exempt-paths",
+				Usage:   "Paths served without credentials (repeatable; a trailing / matches a prefix)",
+				Sources: cli.EnvVars("TSGW_AUTH_EXEMPT_PATHS"),
+			},
+			&cli.StringSliceFlag{
+				Name:    "auth-exempt-tags",
+				Usage:   "Tailscale tags of peers served without credentials (repeatable)",
+				Sources: cli.EnvVars("TSGW_AUTH_EXEMPT_TAGS"),
+			},
+			&cli.StringFlag{
+				Name:    "auth-realm",
+				Usage:   "Realm announced in WWW-Authenticate",
+				Value:   "tsgw",
+				Sources: cli.EnvVars("TSGW_AUTH_REALM"),
+			},
+
+			// Forward-auth (per-route overridable)
+			&cli.StringFlag{
+				Name:    "forward-auth-url",
+				Usage:   "Authorization service asked before proxying; only 2xx answers let requests through",
+				Sources: cli.EnvVars("TSGW_FORWARD_AUTH_URL"),
+				Action: func(ctx context.Context, cmd *cli.Command, value string) error {
+					if value == "" {
+						return nil
+					}
+					if err := validateBackendURL(value); err != nil {
+						return cli.Exit(err.Error(), 1)
+					}
+					return nil
+				},
+			},
+			&cli.StringSliceFlag{
+				Name:    "forward-auth-response-headers",
+				Usage:   "Authorization response headers copied onto the proxied request (repeatable)",
+				Sources: cli.EnvVars("TSGW_FORWARD_AUTH_RESPONSE_HEADERS"),
+			},
+			&cli.DurationFlag{
+				Name:    "forward-auth-timeout",
+				Usage:   "Time allowed for the authorization subrequest",
+				Value:   5 * time.Second,
+				Sources: cli.EnvVars("TSGW_FORWARD_AUTH_TIMEOUT"),
+			},
+			&cli.DurationFlag{
+				Name:    "forward-auth-cache-ttl",
+				Usage:   "How long approvals are reused for the same identity and request (0 disables)",
+				Value:   0,
+				Sources: cli.EnvVars("TSGW_FORWARD_AUTH_CACHE_TTL"),
+			},
+
+			// Client limits (per-route overridable)
+			&cli.Int64Flag{
+				Name:    "max-body-size",
+				Usage:   "Largest request body accepted, in bytes; larger requests get 413 (0 is unlimited)",
+				Value:   0,
+				Sources: cli.EnvVars("TSGW_MAX_BODY_SIZE"),
+			},
+			&cli.IntFlag{
+				Name:    "max-header-bytes",
+				Usage:   "Largest request header block accepted, in bytes; larger requests get 431",
+				Value:   http.DefaultMaxHeaderBytes,
+				Sources: cli.EnvVars("TSGW_MAX_HEADER_BYTES"),
+			},
+			&cli.DurationFlag{
+				Name:    "read-timeout",
+				Usage:   "Time allowed to read a whole request including its body (0 disables)",
+				Value:   0,
+				Sources: cli.EnvVars("TSGW_READ_TIMEOUT"),
+			},
+			&cli.DurationFlag{
+				Name:    "write-timeout",
+				Usage:   "Time allowed to write a whole response (0 disables; keep 0 for long-lived streams)",
+				Value:   0,
+				Sources: cli.EnvVars("TSGW_WRITE_TIMEOUT"),
+			},
+			&cli.Int64Flag{
+				Name:    "min-transfer-rate",
+				Usage:   "Slowest accepted request body upload, in bytes per second; slower clients get 408 (0 disables)",
+				Value:   0,
+				Sources: cli.EnvVars("TSGW_MIN_TRANSFER_RATE"),
+			},
+			&cli.DurationFlag{
+				Name:    "min-transfer-rate-grace",
+				Usage:   "Time before the minimum transfer rate is enforced, also the longest a body read may stall",
+				Value:   10 * time.Second,
+				Sources: cli.EnvVars("TSGW_MIN_TRANSFER_RATE_GRACE"),
+			},
+
 			// OpenTelemetry options
 			&cli.BoolFlag{
 				Name:    "otel-enabled",
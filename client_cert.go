@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Client certificate modes for the tailnet TLS listener
+const (
+	clientCertAuthNone    = "none"
+	clientCertAuthRequest = "request" // Verify a certificate when the client sends one
+	clientCertAuthRequire = "require" // Reject handshakes without a valid certificate
+)
+
+// Headers forwarded to the backend with the verified client certificate.
+// Client supplied values are always removed first.
+const (
+	headerClientCertSubject     = "X-Client-Cert-Subject"
+	headerClientCertFingerprint = "X-Client-Cert-Fingerprint"
+)
+
+func validateClientCertAuth(mode string) error {
+	switch mode {
+	case clientCertAuthNone, clientCertAuthRequest, clientCertAuthRequire:
+		return nil
+	}
+	return fmt.Errorf("invalid client certificate mode %q (valid: none, request, require)", mode)
+}
+
+// newClientCertTLSConfig builds the server TLS settings verifying client
+// certificates against the route CA. The serving certificate is added by
+// listenTLS.
+func newClientCertTLSConfig(cfg ClientCertConfig) (*tls.Config, error) {
+	if cfg.CAFile == "" {
+		return nil, errors.New("client certificate authentication requires a client CA file")
+	}
+	pem, err := os.ReadFile(cfg.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client CA file %s", cfg.CAFile)
+	}
+
+	clientAuth := tls.VerifyClientCertIfGiven
+	if cfg.Mode == clientCertAuthRequire {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	return &tls.Config{ClientAuth: clientAuth, ClientCAs: pool}, nil
+}
+
+// listenTLS listens on the tailnet with the Tailscale-issued certificate,
+// adding client certificate verification when the route asks for it.
+func (rs *RouteServer) listenTLS(addr string) (net.Listener, error) {
+	if rs.clientCertTLS == nil {
+		return rs.Server.ListenTLS("tcp", addr)
+	}
+
+	lc, err := rs.Server.LocalClient()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := rs.Server.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	tlsCfg := rs.clientCertTLS.Clone()
+	tlsCfg.GetCertificate = lc.GetCertificate
+	return tls.NewListener(ln, tlsCfg), nil
+}
+
+// clientCertMiddleware forwards the verified client certificate to the
+// backend and, in require mode, rejects requests that did not present one.
+func (rs *RouteServer) clientCertMiddleware() echo.MiddlewareFunc {
+	mode := rs.route.ClientCert.Mode
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			req.Header.Del(headerClientCertSubject)
+			req.Header.Del(headerClientCertFingerprint)
+
+			if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+				if mode == clientCertAuthRequire {
+					log.Debug().Str("route", rs.RouteName).Str("remote", req.RemoteAddr).Msg("Request without a verified client certificate")
+					return echo.NewHTTPError(http.StatusForbidden, "client certificate required")
+				}
+				return next(c)
+			}
+
+			cert := req.TLS.VerifiedChains[0][0]
+			sum := sha256.Sum256(cert.Raw)
+			req.Header.Set(headerClientCertSubject, cert.Subject.String())
+			req.Header.Set(headerClientCertFingerprint, hex.EncodeToString(sum[:]))
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClientCertServer(t *testing.T, mode, caFile string) *httptest.Server {
+	t.Helper()
+	rs := &RouteServer{
+		RouteName: "test",
+		route:     &RouteConfig{ClientCert: ClientCertConfig{Mode: mode, CAFile: caFile}},
+	}
+	tlsCfg, err := newClientCertTLSConfig(rs.route.ClientCert)
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.Use(rs.clientCertMiddleware())
+	e.GET("/", func(c echo.Context) error {
+		h := c.Request().Header
+		return c.String(http.StatusOK, h.Get(headerClientCertSubject)+"|"+h.Get(headerClientCertFingerprint))
+	})
+
+	srv := httptest.NewUnstartedServer(e)
+	srv.TLS = tlsCfg
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func clientCertGet(t *testing.T, srv *httptest.Server, certs []tls.Certificate, header map[string]string) (int, string, error) {
+	t.Helper()
+	tr := srv.Client().Transport.(*http.Transport).Clone()
+	tr.TLSClientConfig.Certificates = certs
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := (&http.Client{Transport: tr}).Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body), nil
+}
+
+func TestClientCert_Require(t *testing.T) {
+	certFile, keyFile, cert := writeTestClientCert(t)
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	srv := newClientCertServer(t, clientCertAuthRequire, certFile)
+
+	// The handshake fails without a certificate.
+	_, _, err = clientCertGet(t, srv, nil, nil)
+	assert.Error(t, err)
+
+	status, body, err := clientCertGet(t, srv, []tls.Certificate{pair}, map[string]string{headerClientCertSubject: "CN=spoofed"})
+	require.NoError(t, err)
+	sum := sha256.Sum256(cert.Raw)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "CN=tsgw|"+hex.EncodeToString(sum[:]), body)
+}
+
+func TestClientCert_Request(t *testing.T) {
+	certFile, _, _ := writeTestClientCert(t)
+	srv := newClientCertServer(t, clientCertAuthRequest, certFile)
+
+	// Without a certificate the request passes, but spoofed headers are dropped.
+	status, body, err := clientCertGet(t, srv, nil, map[string]string{headerClientCertSubject: "CN=spoofed"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "|", body)
+}
+
+func TestNewClientCertTLSConfig_Errors(t *testing.T) {
+	_, err := newClientCertTLSConfig(ClientCertConfig{Mode: clientCertAuthRequire})
+	assert.Error(t, err)
+
+	_, err = newClientCertTLSConfig(ClientCertConfig{Mode: clientCertAuthRequire, CAFile: "/nonexistent/ca.pem"})
+	assert.Error(t, err)
+}
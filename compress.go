@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Supported content codings, in server preference order
+const (
+	encodingBrotli = "br"
+	encodingZstd   = "zstd"
+	encodingGzip   = "gzip"
+)
+
+func validateCompressionEncodings(encodings []string) error {
+	for _, e := range encodings {
+		switch e {
+		case encodingBrotli, encodingZstd, encodingGzip:
+		default:
+			return fmt.Errorf("invalid compression encoding %q (valid: br, zstd, gzip)", e)
+		}
+	}
+	return nil
+}
+
+func validateCompressionLevel(level string) error {
+	switch level {
+	case "fastest", "default", "best":
+		return nil
+	}
+	return fmt.Errorf("invalid compression level %q (valid: fastest, default, best)", level)
+}
+
+// compressor compresses responses of a route based on Accept-Encoding
+type compressor struct {
+	encodings    []string
+	minSize      int
+	contentTypes []string
+	pools        map[string]*sync.Pool
+}
+
+// resettableWriter is implemented by every pooled encoder
+type resettableWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+func newCompressor(cfg CompressionConfig) *compressor {
+	c := &compressor{
+		encodings:    cfg.Encodings,
+		minSize:      cfg.MinSize,
+		contentTypes: cfg.ContentTypes,
+		pools:        make(map[string]*sync.Pool),
+	}
+	for _, enc := range cfg.Encodings {
+		c.pools[enc] = &sync.Pool{New: newEncoderFunc(enc, cfg.Level)}
+	}
+	return c
+}
+
+func newEncoderFunc(encoding, level string) func() any {
+	switch encoding {
+	case encodingBrotli:
+		lvl := map[string]int{"fastest": brotli.BestSpeed, "default": 5, "best": brotli.BestCompression}[level]
+		return func() any { return brotli.NewWriterLevel(io.Discard, lvl) }
+	case encodingZstd:
+		lvl := map[string]zstd.EncoderLevel{"fastest": zstd.SpeedFastest, "default": zstd.SpeedDefault, "best": zstd.SpeedBestCompression}[level]
+		return func() any {
+			// Concurrency 1 keeps per-response encoders cheap; they are pooled anyway.
+			w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(lvl), zstd.WithEncoderConcurrency(1))
+			return w
+		}
+	default:
+		lvl := map[string]int{"fastest": gzip.BestSpeed, "default": gzip.DefaultCompression, "best": gzip.BestCompression}[level]
+		return func() any {
+			w, _ := gzip.NewWriterLevel(io.Discard, lvl)
+			return w
+		}
+	}
+}
+
+func (cp *compressor) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if req.Method == http.MethodHead || req.Header.Get("Upgrade") != "" {
+			return next(c)
+		}
+		encoding := negotiateEncoding(req.Header.Get("Accept-Encoding"), cp.encodings)
+		if encoding == "" {
+			return next(c)
+		}
+
+		res := c.Response()
+		orig := res.Writer
+		cw := &compressWriter{ResponseWriter: orig, cp: cp, encoding: encoding}
+		res.Writer = cw
+		defer func() {
+			res.Writer = orig
+			if err := cw.Close(); err != nil {
+				log.Debug().Err(err).Msg("Failed to finish compressed response")
+			}
+		}()
+
+		return next(c)
+	}
+}
+
+// shouldCompress inspects the response headers once they are final
+func (cp *compressor) shouldCompress(status int, h http.Header) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	if strings.Contains(strings.ToLower(h.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+	if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil && cl < cp.minSize {
+		return false
+	}
+
+	ct := strings.ToLower(strings.TrimSpace(strings.Split(h.Get("Content-Type"), ";")[0]))
+	if ct == "" {
+		return false
+	}
+	for _, allowed := range cp.contentTypes {
+		if strings.HasSuffix(allowed, "/") && strings.HasPrefix(ct, allowed) || ct == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks the supported coding with the highest q-value,
+// breaking ties with the server preference order.
+func negotiateEncoding(acceptEncoding string, supported []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	q := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[name] = weight
+	}
+
+	candidates := make([]string, 0, len(supported))
+	for _, enc := range supported {
+		w, ok := q[enc]
+		if !ok {
+			w, ok = q["*"]
+		}
+		if ok && w > 0 {
+			candidates = append(candidates, enc)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return weightOf(q, candidates[i]) > weightOf(q, candidates[j])
+	})
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0]
+}
+
+func weightOf(q map[string]float64, enc string) float64 {
+	if w, ok := q[enc]; ok {
+		return w
+	}
+	return q["*"]
+}
+
+// compressWriter buffers the start of the body until minSize bytes are known
+// (or the handler flushes) and then either compresses the rest of the
+// response or passes it through untouched. Flush always reaches the client so
+// server-sent events keep streaming.
+type compressWriter struct {
+	http.ResponseWriter
+	cp       *compressor
+	encoding string
+
+	status      int
+	wroteHeader bool // WriteHeader was called by the handler
+	decided     bool // Headers were sent to the client
+	hijacked    bool
+	buf         bytes.Buffer
+	enc         resettableWriter
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+	// Informational and bodiless responses are sent right away.
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		w.buf.Write(b)
+		if w.buf.Len() < w.cp.minSize {
+			return len(b), nil
+		}
+		if err := w.start(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.decided {
+		_ = w.start()
+	}
+	if w.enc != nil {
+		_ = w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	w.hijacked = true
+	return h.Hijack()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// start decides whether to compress, sends the headers and drains the buffer
+func (w *compressWriter) start() error {
+	w.decide(w.cp.shouldCompress(w.status, w.Header()))
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
+
+func (w *compressWriter) decide(compress bool) {
+	w.decided = true
+	h := w.Header()
+	if compress {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.encoding)
+		h.Add("Vary", "Accept-Encoding")
+		// The representation changes, so a strong validator no longer applies.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		w.enc = w.cp.pools[w.encoding].Get().(resettableWriter)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// Close finishes the response once the handler returned
+func (w *compressWriter) Close() error {
+	if w.hijacked || !w.wroteHeader {
+		return nil
+	}
+	if !w.decided {
+		// The whole body fit in the buffer: only compress if it reached minSize.
+		if w.buf.Len() < w.cp.minSize {
+			w.decide(false)
+			_, err := w.ResponseWriter.Write(w.buf.Bytes())
+			return err
+		}
+		if err := w.start(); err != nil {
+			return err
+		}
+	}
+	if w.enc == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	w.enc.Reset(io.Discard)
+	w.cp.pools[w.encoding].Put(w.enc)
+	w.enc = nil
+	return err
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		Enabled:      true,
+		Encodings:    []string{"br", "zstd", "gzip"},
+		Level:        "default",
+		MinSize:      16,
+		ContentTypes: []string{"text/", "application/json"},
+	}
+}
+
+func newCompressedEcho(handler echo.HandlerFunc) *echo.Echo {
+	e := echo.New()
+	e.Use(newCompressor(testCompressionConfig()).middleware)
+	e.Any("/*", handler)
+	return e
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	supported := []string{"br", "zstd", "gzip"}
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{accept: "", expected: ""},
+		{accept: "gzip, deflate, br", expected: "br"},
+		{accept: "gzip;q=1.0, br;q=0.5", expected: "gzip"},
+		{accept: "zstd, gzip", expected: "zstd"},
+		{accept: "br;q=0, gzip", expected: "gzip"},
+		{accept: "*", expected: "br"},
+		{accept: "identity", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			assert.Equal(t, tt.expected, negotiateEncoding(tt.accept, supported))
+		})
+	}
+}
+
+func TestCompressor_Encodings(t *testing.T) {
+	body := strings.Repeat("compress me please ", 100)
+	e := newCompressedEcho(func(c echo.Context) error {
+		c.Response().Header().Set("ETag", `"abc"`)
+		return c.String(http.StatusOK, body)
+	})
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+		"zstd": func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+
+	for encoding, decode := range decoders {
+		t.Run(encoding, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, encoding, rec.Header().Get("Content-Encoding"))
+			assert.Equal(t, "Accept-Encoding", rec.Header().Get("Vary"))
+			assert.Equal(t, `W/"abc"`, rec.Header().Get("ETag"))
+			assert.Less(t, rec.Body.Len(), len(body))
+
+			r, err := decode(rec.Body)
+			require.NoError(t, err)
+			out, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, body, string(out))
+		})
+	}
+}
+
+func TestCompressor_PassThrough(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler echo.HandlerFunc
+	}{
+		{
+			name: "below minimum size",
+			handler: func(c echo.Context) error {
+				return c.String(http.StatusOK, "tiny")
+			},
+		},
+		{
+			name: "content type not allowed",
+			handler: func(c echo.Context) error {
+				return c.Blob(http.StatusOK, "image/png", []byte(strings.Repeat("x", 100)))
+			},
+		},
+		{
+			name: "already compressed",
+			handler: func(c echo.Context) error {
+				c.Response().Header().Set("Content-Encoding", "gzip")
+				return c.Blob(http.StatusOK, "text/plain", []byte(strings.Repeat("x", 100)))
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newCompressedEcho(tt.handler)
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "br")
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.NotEqual(t, "br", rec.Header().Get("Content-Encoding"))
+		})
+	}
+}
+
+func TestCompressor_StreamingFlush(t *testing.T) {
+	release := make(chan struct{})
+	e := newCompressedEcho(func(c echo.Context) error {
+		c.Response().Header().Set("Content-Type", "text/event-stream")
+		c.Response().WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(c.Response(), "data: first\n\n")
+		c.Response().Flush()
+		<-release
+		return nil
+	})
+
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	// Setting the header disables transparent decompression in the client.
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	defer close(release)
+
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	// The first event must arrive before the handler finishes.
+	zr, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+	line := make(chan string, 1)
+	go func() {
+		l, _ := bufio.NewReader(zr).ReadString('\n')
+		line <- l
+	}()
+	select {
+	case l := <-line:
+		assert.Equal(t, "data: first\n", l)
+	case <-time.After(2 * time.Second):
+		t.Fatal("flushed event was not delivered")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+var (
+	errQueueFull    = errors.New("concurrency queue is full")
+	errQueueTimeout = errors.New("timed out waiting in concurrency queue")
+)
+
+// concurrencyLimiter caps the number of in-flight requests to a backend.
+// Requests over the cap wait in a bounded FIFO queue; a released slot is
+// handed directly to the oldest waiter so ordering is preserved.
+type concurrencyLimiter struct {
+	routeName    string
+	maxInFlight  int
+	maxQueue     int
+	queueTimeout time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	waiters  list.List // of chan struct{}
+
+	queued   metric.Int64Counter
+	rejected metric.Int64Counter
+}
+
+func newConcurrencyLimiter(routeName string, cfg ConcurrencyConfig, meter metric.Meter) *concurrencyLimiter {
+	l := &concurrencyLimiter{
+		routeName:    routeName,
+		maxInFlight:  cfg.MaxInFlight,
+		maxQueue:     cfg.MaxQueue,
+		queueTimeout: cfg.QueueTimeout,
+	}
+
+	var err error
+	l.queued, err = meter.Int64Counter("tsgw.concurrency.queued",
+		metric.WithDescription("Requests that waited for a free backend slot"),
+		metric.WithUnit("{request}"))
+	if err != nil {
+		log.Warn().Err(err).Str("route", routeName).Msg("Failed to create concurrency queued metric")
+	}
+	l.rejected, err = meter.Int64Counter("tsgw.concurrency.rejected",
+		metric.WithDescription("Requests rejected because the backend was at its concurrency limit"),
+		metric.WithUnit("{request}"))
+	if err != nil {
+		log.Warn().Err(err).Str("route", routeName).Msg("Failed to create concurrency rejected metric")
+	}
+
+	return l
+}
+
+// acquire waits for a free slot and returns the function releasing it
+func (l *concurrencyLimiter) acquire(ctx context.Context) (func(), error) {
+	l.mu.Lock()
+	if l.inFlight < l.maxInFlight && l.waiters.Len() == 0 {
+		l.inFlight++
+		l.mu.Unlock()
+		return l.release, nil
+	}
+	if l.waiters.Len() >= l.maxQueue {
+		l.mu.Unlock()
+		l.count(ctx, l.rejected, "queue_full")
+		return nil, errQueueFull
+	}
+	ready := make(chan struct{}, 1)
+	elem := l.waiters.PushBack(ready)
+	l.mu.Unlock()
+
+	l.count(ctx, l.queued, "")
+
+	var timeout <-chan time.Time
+	if l.queueTimeout > 0 {
+		timer := time.NewTimer(l.queueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var err error
+	select {
+	case <-ready:
+		return l.release, nil
+	case <-timeout:
+		err = errQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-ready:
+		// The slot was handed over while we were giving up; keep it.
+		return l.release, nil
+	default:
+	}
+	l.waiters.Remove(elem)
+	if err == errQueueTimeout {
+		l.count(ctx, l.rejected, "timeout")
+	}
+	return nil, err
+}
+
+func (l *concurrencyLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if front := l.waiters.Front(); front != nil {
+		l.waiters.Remove(front)
+		front.Value.(chan struct{}) <- struct{}{}
+		return
+	}
+	l.inFlight--
+}
+
+func (l *concurrencyLimiter) count(ctx context.Context, counter metric.Int64Counter, reason string) {
+	if counter == nil {
+		return
+	}
+	attrs := []attribute.KeyValue{attribute.String("route.name", l.routeName)}
+	if reason != "" {
+		attrs = append(attrs, attribute.String("reason", reason))
+	}
+	counter.Add(context.WithoutCancel(ctx), 1, metric.WithAttributes(attrs...))
+}
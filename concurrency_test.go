@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+)
+
+func TestConcurrencyLimiter_QueueFull(t *testing.T) {
+	l := newConcurrencyLimiter("test", ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 0}, noop.NewMeterProvider().Meter("test"))
+
+	release, err := l.acquire(context.Background())
+	require.NoError(t, err)
+
+	_, err = l.acquire(context.Background())
+	assert.ErrorIs(t, err, errQueueFull)
+
+	release()
+	release, err = l.acquire(context.Background())
+	require.NoError(t, err)
+	release()
+}
+
+func TestConcurrencyLimiter_QueueTimeout(t *testing.T) {
+	l := newConcurrencyLimiter("test", ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 20 * time.Millisecond}, noop.NewMeterProvider().Meter("test"))
+
+	release, err := l.acquire(context.Background())
+	require.NoError(t, err)
+	defer release()
+
+	_, err = l.acquire(context.Background())
+	assert.ErrorIs(t, err, errQueueTimeout)
+	assert.Equal(t, 0, l.waiters.Len())
+}
+
+func TestConcurrencyLimiter_FIFO(t *testing.T) {
+	l := newConcurrencyLimiter("test", ConcurrencyConfig{MaxInFlight: 1, MaxQueue: 2}, noop.NewMeterProvider().Meter("test"))
+
+	release, err := l.acquire(context.Background())
+	require.NoError(t, err)
+
+	order := make(chan int, 2)
+	for i := 1; i <= 2; i++ {
+		go func() {
+			r, err := l.acquire(context.Background())
+			if err != nil {
+				return
+			}
+			order <- i
+			r()
+		}()
+		// Make sure the waiters enqueue in order.
+		require.Eventually(t, func() bool {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			return l.waiters.Len() == i
+		}, time.Second, time.Millisecond)
+	}
+
+	release()
+	assert.Equal(t, 1, <-order)
+	assert.Equal(t, 2, <-order)
+
+	require.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.inFlight == 0
+	}, time.Second, time.Millisecond)
+}
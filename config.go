@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"strings"
 	"time"
@@ -11,7 +13,7 @@ import (
 )
 
 type Config struct {
-	TailscaleTag    string
+	Tags            []string // ACL tags for route nodes, overridable per route
 	OAuth           OAuthConfig
 	OpenTelemetry   OpenTelemetryConfig
 	Pyroscope       PyroscopeConfig
@@ -21,19 +23,198 @@ type Config struct {
 	LogFormat       string
 	SkipTLSVerify   bool
 	TailscaleDomain string
+	ControlURL      string // Coordination server, e.g. Headscale; empty uses Tailscale
+	KeyProvider     KeyProviderConfig
+	AuthKey         AuthKeyConfig
 	TsnetDir        string
 	ForceCleanup    bool
-	Routes          map[string]string // name -> backend URL
+	Ephemeral       bool
+	Routes          map[string]string            // name -> backend URL
+	RouteOptions    map[string]map[string]string // name -> option key -> raw value
+	Admin           AdminConfig
 
 	// Timeouts and limits
 	ConnectTimeout time.Duration
 	RequestTimeout time.Duration
+
+	// Defaults applied to every route, overridable with --route-option
+	Retry       RetryConfig
+	RateLimit   RateLimitConfig
+	Concurrency ConcurrencyConfig
+	Cache       CacheConfig
+	Compression CompressionConfig
+	Limits      LimitsConfig
+	UpstreamTLS UpstreamTLSConfig
+	ClientCert  ClientCertConfig
+	OIDC        OIDCConfig
+	Auth        AuthConfig
+	ForwardAuth ForwardAuthConfig
+	StaleDevice StaleDeviceConfig
+	Reconcile   string // Device reconciliation policy: off, warn or apply
+	DialVia     string // How backends are reached: host or tailnet
+	State       StateConfig
+	KeyExpiry   KeyExpiryConfig
+	Node        NodeConfig
+}
+
+// RouteConfig is the effective configuration of a single route: the global
+// defaults with any --route-option overrides for that route applied.
+type RouteConfig struct {
+	Name        string
+	AuthKey     AuthKeyConfig
+	Tags        []string
+	Ephemeral   bool     // Register the node as ephemeral and delete it on shutdown
+	Upstreams   []string // Additional backend URLs balanced alongside the primary one
+	DialVia     string   // How backends are reached: host or tailnet
+	Retry       RetryConfig
+	RateLimit   RateLimitConfig
+	Concurrency ConcurrencyConfig
+	Cache       CacheConfig
+	Compression CompressionConfig
+	Limits      LimitsConfig
+	UpstreamTLS UpstreamTLSConfig
+	ClientCert  ClientCertConfig
+	OIDC        OIDCConfig
+	Auth        AuthConfig
+	ForwardAuth ForwardAuthConfig
+	StaleDevice StaleDeviceConfig
+	Reconcile   string // Device reconciliation policy: off, warn or apply
+	State       StateConfig
+	KeyExpiry   KeyExpiryConfig
+	Node        NodeConfig
+}
+
+type RetryConfig struct {
+	Attempts   int           // Extra attempts after the first one, 0 disables retries
+	TryTimeout time.Duration // Time to wait for response headers per attempt, 0 disables
+	Backoff    time.Duration // Base delay between attempts, doubled on every retry
+	On         []string      // Failure classes to retry: connect, reset, timeout, 502, 503, 504
+	MaxBody    int64         // Largest request body buffered so it can be replayed
+}
+
+type RateLimitConfig struct {
+	Rate  float64 // Sustained requests per second per client, 0 disables
+	Burst int     // Bucket size, defaults to the rate rounded up
+	Key   string  // What identifies a client: node, user, tag or ip
+}
+
+type ConcurrencyConfig struct {
+	MaxInFlight  int           // Requests proxied to the backend at once, 0 is unlimited
+	MaxQueue     int           // Requests waiting for a free slot before rejecting
+	QueueTimeout time.Duration // Longest a request waits in the queue, 0 waits forever
+}
+
+type CacheConfig struct {
+	Enabled       bool
+	MaxSize       int64    // Memory budget of the LRU in bytes
+	MaxObjectSize int64    // Largest response body stored
+	DiskMaxSize   int64    // Size cap of the on-disk store under TsnetDir, 0 disables it
+	PurgeAllow    []string // User logins, tags or node names allowed to purge
+}
+
+type CompressionConfig struct {
+	Enabled      bool
+	Encodings    []string // Offered codings in preference order: br, zstd, gzip
+	Level        string   // fastest, default or best
+	MinSize      int      // Smallest body worth compressing, in bytes
+	ContentTypes []string // Compressible media types; entries ending in "/" match a prefix
+}
+
+type LimitsConfig struct {
+	MaxBodySize          int64         // Largest request body accepted, 0 is unlimited
+	MaxHeaderBytes       int           // Largest request header block accepted
+	ReadTimeout          time.Duration // Time to read the whole request, 0 disables
+	WriteTimeout         time.Duration // Time to write the whole response, 0 disables
+	MinTransferRate      int64         // Slowest accepted upload in bytes per second, 0 disables
+	MinTransferRateGrace time.Duration // Time before the transfer rate is enforced
+}
+
+type UpstreamTLSConfig struct {
+	SkipVerify bool     // Per-route --skip-tls-verify; defaults to the global flag
+	CAFile     string   // PEM bundle trusted instead of the system roots
+	ServerName string   // SNI and verification name, defaults to the backend host
+	PinSHA256  []string // Accepted SHA-256 fingerprints of the backend leaf certificate
+	ClientCert string   // PEM client certificate presented for mTLS
+	ClientKey  string   // PEM private key of the client certificate
+}
+
+type ClientCertConfig struct {
+	Mode   string // none, request or require
+	CAFile string // PEM bundle client certificates are verified against
+}
+
+type OIDCConfig struct {
+	Issuer         string // Provider issuer URL; empty disables OIDC
+	ClientID       string
+	ClientSecret   string
+	RedirectURL    string   // Defaults to https://<host>/.tsgw/oidc/callback
+	Scopes         []string // Requested scopes, including openid
+	CookieSecret   string   // Key material for the encrypted session cookie
+	SessionTTL     time.Duration
+	GroupsClaim    string   // ID token claim listing the user groups
+	AllowedGroups  []string // Groups allowed in, empty allows everyone
+	RequiredClaims []string // claim=value pairs every login must carry
+}
+
+type AuthConfig struct {
+	HtpasswdFile string   // user:hash lines (bcrypt or argon2id) for basic auth
+	APIKeysFile  string   // name:key lines accepted as bearer tokens or X-API-Key
+	ExemptPaths  []string // Paths served without credentials; entries ending in "/" match a prefix
+	ExemptTags   []string // Tailscale tags of peers served without credentials
+	Realm        string
+}
+
+type ForwardAuthConfig struct {
+	URL             string        // Authorization service; empty disables forward-auth
+	ResponseHeaders []string      // Headers copied from an approval onto the proxied request
+	Timeout         time.Duration // Time allowed for the authorization subrequest
+	CacheTTL        time.Duration // How long approvals are reused per identity, 0 disables
+}
+
+type StaleDeviceConfig struct {
+	Policy       string        // off, warn, delete or fail
+	OfflineAfter time.Duration // How long a device must have been offline to count as stale
+}
+
+type StateConfig struct {
+	Store            string // dir, mem, kube or encrypted
+	KubeSecretPrefix string // Prefix of the per-route Kubernetes Secret name
+	EncryptionKey    string // Secret the encrypted store key is derived from
+}
+
+type KeyExpiryConfig struct {
+	Warning     time.Duration // Warn when the node key expires within this time
+	RenewBefore time.Duration // Re-authenticate when the key expires within this time, 0 disables
+	Disable     bool          // Disable key expiry for the route device through the API
+}
+
+// NodeConfig holds the route node preferences that may differ from the
+// locked-down defaults
+type NodeConfig struct {
+	Hostname     string // Advertised hostname; empty uses the route name
+	AcceptRoutes bool
+	AcceptDNS    bool
+}
+
+type KeyProviderConfig struct {
+	Provider        string // tailscale, headscale or static
+	HeadscaleURL    string // Headscale API URL, defaults to the control URL
+	HeadscaleAPIKey string
+	HeadscaleUser   string // User owning minted pre-auth keys (ID or name, depending on the Headscale version)
+}
+
+// AuthKeyConfig is a pre-generated auth key, given directly or as a file
+// read whenever a key is needed
+type AuthKeyConfig struct {
+	Key  string
+	File string
 }
 
 type OAuthConfig struct {
 	ClientID     string
 	ClientSecret string
 	Issuer       string // OAuth issuer URL, defaults to Tailscale
+	IDTokenFile  string // OIDC ID token exchanged instead of using the client secret
 }
 
 type OpenTelemetryConfig struct {
@@ -45,6 +226,11 @@ type OpenTelemetryConfig struct {
 	Headers     map[string]string // Additional headers for OTLP requests
 }
 
+type AdminConfig struct {
+	Listen   string // Loopback address of the admin API; empty disables it
+	Hostname string // Tailnet node serving the admin API; empty disables it
+}
+
 type PyroscopeConfig struct {
 	Enabled         bool
 	ServerAddress   string // e.g. http://pyroscope:4040
@@ -63,13 +249,15 @@ type PyroscopeConfig struct {
 // buildConfigFromCLI builds a Config struct directly from CLI flag values
 func buildConfigFromCLI(cmd *cli.Command) *Config {
 	config := &Config{
-		TailscaleTag:    cmd.String("tailscale-tag"),
+		Tags:            normalizeTags(cmd.StringSlice("tailscale-tag")),
 		TailscaleDomain: cmd.String("tailscale-domain"),
+		ControlURL:      cmd.String("control-url"),
 		HTTPPort:        cmd.Int("http-port"),
 		HTTPSPort:       cmd.Int("https-port"),
 		LogLevel:        cmd.String("log-level"),
 		LogFormat:       cmd.String("log-format"),
 		SkipTLSVerify:   cmd.Bool("skip-tls-verify"),
+		Ephemeral:       cmd.Bool("ephemeral"),
 		TsnetDir:        cmd.String("tsnet-dir"),
 		ForceCleanup:    cmd.Bool("force-cleanup"),
 
@@ -77,6 +265,24 @@ func buildConfigFromCLI(cmd *cli.Command) *Config {
 			ClientID:     cmd.String("oauth-client-id"),
 			ClientSecret: cmd.String("oauth-client-secret"),
 			Issuer:       cmd.String("oauth-issuer"),
+			IDTokenFile:  cmd.String("oauth-id-token-file"),
+		},
+
+		Admin: AdminConfig{
+			Listen:   cmd.String("admin-listen"),
+			Hostname: cmd.String("admin-hostname"),
+		},
+
+		AuthKey: AuthKeyConfig{
+			Key:  cmd.String("auth-key"),
+			File: cmd.String("auth-key-file"),
+		},
+
+		KeyProvider: KeyProviderConfig{
+			Provider:        cmd.String("auth-key-provider"),
+			HeadscaleURL:    cmd.String("headscale-url"),
+			HeadscaleAPIKey: cmd.String("headscale-api-key"),
+			HeadscaleUser:   cmd.String("headscale-user"),
 		},
 
 		OpenTelemetry: OpenTelemetryConfig{
@@ -101,6 +307,117 @@ func buildConfigFromCLI(cmd *cli.Command) *Config {
 
 		ConnectTimeout: cmd.Duration("connect-timeout"),
 		RequestTimeout: cmd.Duration("request-timeout"),
+
+		Retry: RetryConfig{
+			Attempts:   cmd.Int("retry-attempts"),
+			TryTimeout: cmd.Duration("retry-try-timeout"),
+			Backoff:    cmd.Duration("retry-backoff"),
+			On:         append([]string{}, cmd.StringSlice("retry-on")...),
+			MaxBody:    cmd.Int64("retry-max-body"),
+		},
+
+		RateLimit: RateLimitConfig{
+			Rate:  cmd.Float64("rate-limit"),
+			Burst: cmd.Int("rate-limit-burst"),
+			Key:   cmd.String("rate-limit-key"),
+		},
+
+		Concurrency: ConcurrencyConfig{
+			MaxInFlight:  cmd.Int("max-in-flight"),
+			MaxQueue:     cmd.Int("max-queue"),
+			QueueTimeout: cmd.Duration("queue-timeout"),
+		},
+
+		Cache: CacheConfig{
+			Enabled:       cmd.Bool("cache"),
+			MaxSize:       cmd.Int64("cache-max-size"),
+			MaxObjectSize: cmd.Int64("cache-max-object-size"),
+			DiskMaxSize:   cmd.Int64("cache-disk-max-size"),
+			PurgeAllow:    append([]string{}, cmd.StringSlice("cache-purge-allow")...),
+		},
+
+		Compression: CompressionConfig{
+			Enabled:      cmd.Bool("compression"),
+			Encodings:    append([]string{}, cmd.StringSlice("compression-encodings")...),
+			Level:        cmd.String("compression-level"),
+			MinSize:      cmd.Int("compression-min-size"),
+			ContentTypes: append([]string{}, cmd.StringSlice("compression-content-types")...),
+		},
+
+		UpstreamTLS: UpstreamTLSConfig{
+			CAFile:     cmd.String("upstream-ca-file"),
+			ServerName: cmd.String("upstream-server-name"),
+			PinSHA256:  append([]string{}, cmd.StringSlice("upstream-pin-sha256")...),
+			ClientCert: cmd.String("upstream-client-cert"),
+			ClientKey:  cmd.String("upstream-client-key"),
+		},
+
+		ClientCert: ClientCertConfig{
+			Mode:   cmd.String("client-cert-auth"),
+			CAFile: cmd.String("client-ca-file"),
+		},
+
+		OIDC: OIDCConfig{
+			Issuer:         cmd.String("oidc-issuer"),
+			ClientID:       cmd.String("oidc-client-id"),
+			ClientSecret:   cmd.String("oidc-client-secret"),
+			RedirectURL:    cmd.String("oidc-redirect-url"),
+			Scopes:         append([]string{}, cmd.StringSlice("oidc-scopes")...),
+			CookieSecret:   cmd.String("oidc-cookie-secret"),
+			SessionTTL:     cmd.Duration("oidc-session-ttl"),
+			GroupsClaim:    cmd.String("oidc-groups-claim"),
+			AllowedGroups:  append([]string{}, cmd.StringSlice("oidc-allowed-groups")...),
+			RequiredClaims: append([]string{}, cmd.StringSlice("oidc-required-claims")...),
+		},
+
+		Auth: AuthConfig{
+			HtpasswdFile: cmd.String("basic-auth-file"),
+			APIKeysFile:  cmd.String("api-keys-file"),
+			ExemptPaths:  append([]string{}, cmd.StringSlice("auth-exempt-paths")...),
+			ExemptTags:   append([]string{}, cmd.StringSlice("auth-exempt-tags")...),
+			Realm:        cmd.String("auth-realm"),
+		},
+
+		ForwardAuth: ForwardAuthConfig{
+			URL:             cmd.String("forward-auth-url"),
+			ResponseHeaders: append([]string{}, cmd.StringSlice("forward-auth-response-headers")...),
+			Timeout:         cmd.Duration("forward-auth-timeout"),
+			CacheTTL:        cmd.Duration("forward-auth-cache-ttl"),
+		},
+
+		Limits: LimitsConfig{
+			MaxBodySize:          cmd.Int64("max-body-size"),
+			MaxHeaderBytes:       cmd.Int("max-header-bytes"),
+			ReadTimeout:          cmd.Duration("read-timeout"),
+			WriteTimeout:         cmd.Duration("write-timeout"),
+			MinTransferRate:      cmd.Int64("min-transfer-rate"),
+			MinTransferRateGrace: cmd.Duration("min-transfer-rate-grace"),
+		},
+
+		StaleDevice: StaleDeviceConfig{
+			Policy:       cmd.String("stale-device-policy"),
+			OfflineAfter: cmd.Duration("stale-device-offline-after"),
+		},
+
+		Reconcile: cmd.String("device-reconcile"),
+		DialVia:   cmd.String("dial-via"),
+
+		Node: NodeConfig{
+			AcceptRoutes: cmd.Bool("accept-routes"),
+			AcceptDNS:    cmd.Bool("accept-dns"),
+		},
+
+		State: StateConfig{
+			Store:            cmd.String("state-store"),
+			KubeSecretPrefix: cmd.String("state-kube-secret-prefix"),
+			EncryptionKey:    cmd.String("state-encryption-key"),
+		},
+
+		KeyExpiry: KeyExpiryConfig{
+			Warning:     cmd.Duration("key-expiry-warning"),
+			RenewBefore: cmd.Duration("key-expiry-renew-before"),
+			Disable:     cmd.Bool("disable-key-expiry"),
+		},
 	}
 
 	// Parse Pyroscope tags
@@ -147,8 +464,25 @@ func buildConfigFromCLI(cmd *cli.Command) *Config {
 		}
 	}
 
+	// Parse per-route overrides
+	config.RouteOptions = make(map[string]map[string]string)
+	for _, opt := range cmd.StringSlice("route-option") {
+		routeName, key, value, err := parseRouteOption(opt)
+		if err != nil {
+			continue
+		}
+		if config.RouteOptions[routeName] == nil {
+			config.RouteOptions[routeName] = make(map[string]string)
+		}
+		config.RouteOptions[routeName][key] = value
+	}
+
 	// Parse routes from TSGW_ROUTE_* environment variables
 	for _, env := range os.Environ() {
+		if strings.HasPrefix(env, "TSGW_ROUTE_OPTIONS=") {
+			// Per-route overrides, handled by the route-option flag
+			continue
+		}
 		if strings.HasPrefix(env, "TSGW_ROUTE_") {
 			parts := strings.SplitN(env, "=", 2)
 			if len(parts) == 2 {
@@ -162,6 +496,67 @@ func buildConfigFromCLI(cmd *cli.Command) *Config {
 	return config
 }
 
+// RouteConfig returns the effective configuration for the named route
+func (c *Config) RouteConfig(routeName string) (*RouteConfig, error) {
+	rc := &RouteConfig{
+		Name:        routeName,
+		Tags:        append([]string{}, c.Tags...),
+		Ephemeral:   c.Ephemeral,
+		DialVia:     c.DialVia,
+		AuthKey:     c.AuthKey,
+		Retry:       c.Retry,
+		RateLimit:   c.RateLimit,
+		Concurrency: c.Concurrency,
+		Cache:       c.Cache,
+		Compression: c.Compression,
+		Limits:      c.Limits,
+		UpstreamTLS: c.UpstreamTLS,
+		ClientCert:  c.ClientCert,
+		OIDC:        c.OIDC,
+		Auth:        c.Auth,
+		ForwardAuth: c.ForwardAuth,
+		StaleDevice: c.StaleDevice,
+		Reconcile:   c.Reconcile,
+		State:       c.State,
+		KeyExpiry:   c.KeyExpiry,
+		Node:        c.Node,
+	}
+	rc.UpstreamTLS.SkipVerify = c.SkipTLSVerify
+	rc.Retry.On = append([]string{}, c.Retry.On...)
+	rc.Cache.PurgeAllow = append([]string{}, c.Cache.PurgeAllow...)
+	rc.Compression.Encodings = append([]string{}, c.Compression.Encodings...)
+	rc.Compression.ContentTypes = append([]string{}, c.Compression.ContentTypes...)
+	rc.UpstreamTLS.PinSHA256 = append([]string{}, c.UpstreamTLS.PinSHA256...)
+	rc.OIDC.Scopes = append([]string{}, c.OIDC.Scopes...)
+	rc.OIDC.AllowedGroups = append([]string{}, c.OIDC.AllowedGroups...)
+	rc.OIDC.RequiredClaims = append([]string{}, c.OIDC.RequiredClaims...)
+	rc.Auth.ExemptPaths = append([]string{}, c.Auth.ExemptPaths...)
+	rc.Auth.ExemptTags = append([]string{}, c.Auth.ExemptTags...)
+	rc.ForwardAuth.ResponseHeaders = append([]string{}, c.ForwardAuth.ResponseHeaders...)
+
+	for key, value := range c.RouteOptions[routeName] {
+		if err := applyRouteOption(rc, key, value); err != nil {
+			return nil, fmt.Errorf("route %s: %w", routeName, err)
+		}
+	}
+
+	if err := rc.validateAuth(); err != nil {
+		return nil, fmt.Errorf("route %s: %w", routeName, err)
+	}
+
+	return rc, nil
+}
+
+// validateAuth rejects authentication methods that cannot be combined.
+// OIDC and credential auth both set the identity headers, and credentials
+// would have to be entered on top of the OIDC login.
+func (rc *RouteConfig) validateAuth() error {
+	if rc.OIDC.Issuer != "" && (rc.Auth.HtpasswdFile != "" || rc.Auth.APIKeysFile != "") {
+		return errors.New("OIDC cannot be combined with basic-auth-file or api-keys-file")
+	}
+	return nil
+}
+
 // SetupLogging configures the logging level and format from the loaded configuration
 func SetupLogging(config *Config) {
 	// Configure log format
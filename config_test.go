@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -69,6 +70,18 @@ func TestServer_LogRoutes(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "hostname override",
+			config: &Config{
+				TailscaleDomain: "example.ts.net",
+				Routes: map[string]string{
+					"api": "https://api.internal:3000",
+				},
+				RouteOptions: map[string]map[string]string{
+					"api": {"hostname": "api-gw"},
+				},
+			},
+		},
 		{
 			name: "empty routes",
 			config: &Config{
@@ -159,3 +172,115 @@ func TestSetupLogging(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRouteOption(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		route       string
+		key         string
+		value       string
+		expectError bool
+	}{
+		{
+			name:  "simple option",
+			input: "app:retry-attempts=3",
+			route: "app",
+			key:   "retry-attempts",
+			value: "3",
+		},
+		{
+			name:  "value containing separators",
+			input: "App:upstreams=http://a:8080|http://b:8080",
+			route: "app",
+			key:   "upstreams",
+			value: "http://a:8080|http://b:8080",
+		},
+		{
+			name:        "missing value",
+			input:       "app:retry-attempts",
+			expectError: true,
+		},
+		{
+			name:        "missing route",
+			input:       "retry-attempts=3",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, key, value, err := parseRouteOption(tt.input)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.route, route)
+			assert.Equal(t, tt.key, key)
+			assert.Equal(t, tt.value, value)
+		})
+	}
+}
+
+func TestConfig_RouteConfig(t *testing.T) {
+	config := &Config{
+		SkipTLSVerify: true,
+		Retry: RetryConfig{
+			Attempts: 1,
+			Backoff:  100 * time.Millisecond,
+			On:       []string{"connect"},
+		},
+		RouteOptions: map[string]map[string]string{
+			"api": {
+				"retry-attempts":  "3",
+				"retry-on":        "connect|503",
+				"upstreams":       "http://api-2.internal:3000",
+				"skip-tls-verify": "false",
+			},
+			"bad": {
+				"retry-attempts": "many",
+			},
+			"sso": {
+				"oidc-issuer":     "https://login.example.com",
+				"basic-auth-file": "/etc/tsgw/sso.htpasswd",
+			},
+		},
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		rc, err := config.RouteConfig("app")
+		assert.NoError(t, err)
+		assert.Equal(t, config.Retry, rc.Retry)
+		assert.Empty(t, rc.Upstreams)
+		assert.True(t, rc.UpstreamTLS.SkipVerify)
+	})
+
+	t.Run("overrides", func(t *testing.T) {
+		rc, err := config.RouteConfig("api")
+		assert.NoError(t, err)
+		assert.Equal(t, 3, rc.Retry.Attempts)
+		assert.Equal(t, 100*time.Millisecond, rc.Retry.Backoff)
+		assert.Equal(t, []string{"connect", "503"}, rc.Retry.On)
+		assert.Equal(t, []string{"http://api-2.internal:3000"}, rc.Upstreams)
+		assert.False(t, rc.UpstreamTLS.SkipVerify)
+		assert.Equal(t, []string{"connect"}, config.Retry.On, "defaults must not be modified")
+	})
+
+	t.Run("invalid override", func(t *testing.T) {
+		_, err := config.RouteConfig("bad")
+		assert.Error(t, err)
+	})
+
+	t.Run("OIDC with credential auth", func(t *testing.T) {
+		_, err := config.RouteConfig("sso")
+		assert.ErrorContains(t, err, "OIDC cannot be combined")
+	})
+}
+
+func TestValidateUpstreams(t *testing.T) {
+	assert.NoError(t, validateUpstreams("http://a:8080", []string{"http://b:8080", "https://c/"}))
+	assert.NoError(t, validateUpstreams("http://a:8080/api/", []string{"http://b:8080/api"}))
+	assert.Error(t, validateUpstreams("http://a:8080", []string{"http://b:8080/api"}))
+	assert.Error(t, validateUpstreams("http://a:8080/api", []string{"http://b:8080/v2"}))
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"tailscale.com/client/tailscale/v2"
+	"tailscale.com/tailcfg"
+)
+
+// deviceReconcileInterval is how often a running route compares its device
+// with the route configuration
+const deviceReconcileInterval = 15 * time.Minute
+
+// Device reconciliation policies
+const (
+	reconcilePolicyOff   = "off"   // Do not compare the device with the route configuration
+	reconcilePolicyWarn  = "warn"  // Log attributes that drifted
+	reconcilePolicyApply = "apply" // Log and correct attributes that drifted
+)
+
+func validateReconcilePolicy(policy string) error {
+	switch policy {
+	case reconcilePolicyOff, reconcilePolicyWarn, reconcilePolicyApply:
+		return nil
+	}
+	return fmt.Errorf("invalid device reconcile policy %q (valid: off, warn, apply)", policy)
+}
+
+// deviceDrift is an attribute of a registered device that no longer matches
+// the route configuration
+type deviceDrift struct {
+	attribute string
+	current   any
+	desired   any
+	apply     func(ctx context.Context, devices *tailscale.DevicesResource, deviceID string) error
+}
+
+// findDeviceDrift compares a route device with the route configuration.
+// Tags are only compared for routes minting their auth keys, since a
+// pre-generated key decides the tags itself. Key expiry only drifts when
+// --disable-key-expiry is set and the device still expires; expiry is never
+// turned back on, since Tailscale disables it on tagged devices by default.
+func findDeviceDrift(device *tailscale.Device, route *RouteConfig) []deviceDrift {
+	var drift []deviceDrift
+
+	if route.AuthKey == (AuthKeyConfig{}) && len(route.Tags) > 0 {
+		current := slices.Sorted(slices.Values(device.Tags))
+		desired := slices.Sorted(slices.Values(route.Tags))
+		if !slices.Equal(current, desired) {
+			drift = append(drift, deviceDrift{
+				attribute: "tags",
+				current:   device.Tags,
+				desired:   route.Tags,
+				apply: func(ctx context.Context, devices *tailscale.DevicesResource, deviceID string) error {
+					return devices.SetTags(ctx, deviceID, route.Tags)
+				},
+			})
+		}
+	}
+
+	if route.KeyExpiry.Disable && !device.KeyExpiryDisabled {
+		drift = append(drift, deviceDrift{
+			attribute: "key_expiry_disabled",
+			current:   false,
+			desired:   true,
+			apply: func(ctx context.Context, devices *tailscale.DevicesResource, deviceID string) error {
+				return devices.SetKey(ctx, deviceID, tailscale.DeviceKey{KeyExpiryDisabled: true})
+			},
+		})
+	}
+
+	return drift
+}
+
+// runDeviceReconcile reconciles the route device right away and then every
+// interval until ctx is done, so changes made while the route runs are
+// caught as well.
+func runDeviceReconcile(ctx context.Context, tsClient *tailscale.Client, route *RouteConfig, nodeID tailcfg.StableNodeID, interval time.Duration) {
+	if tsClient == nil || route.Reconcile == reconcilePolicyOff || nodeID == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		reconcileDevice(ctx, tsClient, route, nodeID)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcileDevice compares the registered device of a running route with
+// the route configuration and, with the apply policy, corrects it through
+// the devices API. Failures are logged; they never take the route down.
+func reconcileDevice(ctx context.Context, tsClient *tailscale.Client, route *RouteConfig, nodeID tailcfg.StableNodeID) {
+	if tsClient == nil || route.Reconcile == reconcilePolicyOff || nodeID == "" {
+		return
+	}
+	logger := log.With().Str("route", route.Name).Str("node_id", string(nodeID)).Logger()
+
+	device, err := tsClient.Devices().Get(ctx, string(nodeID))
+	if err != nil {
+		logger.Warn().Err(err).Msg("Failed to get device; skipping device reconciliation")
+		return
+	}
+
+	for _, d := range findDeviceDrift(device, route) {
+		event := logger.Warn().Str("attribute", d.attribute).Interface("current", d.current).Interface("desired", d.desired)
+		if route.Reconcile != reconcilePolicyApply {
+			event.Msg("Device drifted from the route configuration (set --device-reconcile apply to correct it)")
+			continue
+		}
+		event.Msg("Device drifted from the route configuration; correcting it")
+		if err := d.apply(ctx, tsClient.Devices(), string(nodeID)); err != nil {
+			logger.Warn().Err(err).Str("attribute", d.attribute).Msg("Failed to correct device")
+			continue
+		}
+		logger.Info().Str("attribute", d.attribute).Msg("Device corrected")
+	}
+}
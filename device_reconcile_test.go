@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/v2"
+)
+
+func TestFindDeviceDrift(t *testing.T) {
+	device := &tailscale.Device{Tags: []string{"tag:prod-db", "tag:tsgw"}}
+
+	route := &RouteConfig{Name: "db", Tags: []string{"tag:tsgw", "tag:prod-db"}}
+	assert.Empty(t, findDeviceDrift(device, route), "tag order does not matter")
+
+	route.Tags = []string{"tag:tsgw"}
+	route.KeyExpiry.Disable = true
+	var attributes []string
+	for _, d := range findDeviceDrift(device, route) {
+		attributes = append(attributes, d.attribute)
+	}
+	assert.Equal(t, []string{"tags", "key_expiry_disabled"}, attributes)
+
+	// A pre-generated auth key decides the tags itself.
+	route.AuthKey = AuthKeyConfig{Key: "tskey-auth-db"}
+	device.KeyExpiryDisabled = true
+	assert.Empty(t, findDeviceDrift(device, route))
+
+	// Expiry disabled by default or by hand is never turned back on.
+	route.KeyExpiry.Disable = false
+	assert.Empty(t, findDeviceDrift(device, route))
+	device.KeyExpiryDisabled = false
+	assert.Empty(t, findDeviceDrift(device, route))
+}
+
+func TestReconcileDevice(t *testing.T) {
+	for _, policy := range []string{reconcilePolicyOff, reconcilePolicyWarn, reconcilePolicyApply} {
+		t.Run(policy, func(t *testing.T) {
+			var fetched bool
+			writes := map[string]string{}
+			client := newFakeTailscaleAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					fetched = true
+					assert.Equal(t, "/api/v2/device/nDB", r.URL.Path)
+					_ = json.NewEncoder(w).Encode(tailscale.Device{NodeID: "nDB", Tags: []string{"tag:tsgw"}})
+				case http.MethodPost:
+					body, _ := io.ReadAll(r.Body)
+					writes[r.URL.Path] = string(body)
+				}
+			})
+
+			route := &RouteConfig{
+				Name:      "db",
+				Tags:      []string{"tag:tsgw", "tag:prod-db"},
+				Reconcile: policy,
+				KeyExpiry: KeyExpiryConfig{Disable: true},
+			}
+			reconcileDevice(context.Background(), client, route, "nDB")
+
+			assert.Equal(t, policy != reconcilePolicyOff, fetched)
+			if policy != reconcilePolicyApply {
+				assert.Empty(t, writes)
+				return
+			}
+			assert.JSONEq(t, `{"tags":["tag:tsgw","tag:prod-db"]}`, writes["/api/v2/device/nDB/tags"])
+			assert.JSONEq(t, `{"keyExpiryDisabled":true}`, writes["/api/v2/device/nDB/key"])
+		})
+	}
+}
+
+func TestReconcileDevice_GetFailure(t *testing.T) {
+	var writes int
+	client := newFakeTailscaleAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writes++
+		}
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	route := &RouteConfig{Name: "db", Tags: []string{"tag:tsgw"}, Reconcile: reconcilePolicyApply}
+	assert.NotPanics(t, func() { reconcileDevice(context.Background(), client, route, "nDB") })
+	assert.Zero(t, writes)
+
+	// A missing API client is not an error.
+	assert.NotPanics(t, func() { reconcileDevice(context.Background(), nil, route, "nDB") })
+}
+
+func TestRunDeviceReconcile_Periodic(t *testing.T) {
+	var fetches atomic.Int32
+	client := newFakeTailscaleAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			fetches.Add(1)
+		}
+		_ = json.NewEncoder(w).Encode(tailscale.Device{NodeID: "nDB", Tags: []string{"tag:tsgw"}})
+	})
+	route := &RouteConfig{Name: "db", Tags: []string{"tag:tsgw"}, Reconcile: reconcilePolicyWarn}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runDeviceReconcile(ctx, client, route, "nDB", 10*time.Millisecond)
+	}()
+	require.Eventually(t, func() bool { return fetches.Load() >= 3 }, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+}
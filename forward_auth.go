@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Identity headers sent to the authorization service, named like the ones
+// `tailscale serve` adds.
+const (
+	headerTailscaleUserLogin = "Tailscale-User-Login"
+	headerTailscaleNodeName  = "Tailscale-Node-Name"
+	headerTailscaleNodeTags  = "Tailscale-Node-Tags"
+)
+
+// forwardAuthMaxBody caps the denial body relayed back to the client
+const forwardAuthMaxBody = 64 * 1024
+
+// forwardAuthMaxEntries bounds the decision cache
+const forwardAuthMaxEntries = 10000
+
+// forwardAuthSkipHeaders are connection-specific and never copied between
+// the client, the authorization service and the backend.
+var forwardAuthSkipHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length",
+}
+
+// forwardAuth asks an external service whether a request may be proxied,
+// like Traefik ForwardAuth or nginx auth_request.
+type forwardAuth struct {
+	routeName       string
+	url             string
+	responseHeaders []string
+	cacheTTL        time.Duration
+	client          *http.Client
+	identify        func(c echo.Context) *peerIdentity
+
+	mu    sync.Mutex
+	cache map[string]forwardAuthDecision
+
+	decisions metric.Int64Counter
+}
+
+// forwardAuthDecision is a cached approval with the headers to add upstream
+type forwardAuthDecision struct {
+	header  http.Header
+	expires time.Time
+}
+
+func newForwardAuth(rs *RouteServer, meter metric.Meter) *forwardAuth {
+	cfg := rs.route.ForwardAuth
+	fa := &forwardAuth{
+		routeName:       rs.RouteName,
+		url:             cfg.URL,
+		responseHeaders: cfg.ResponseHeaders,
+		cacheTTL:        cfg.CacheTTL,
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			// Redirects are the authorization service's answer to the client.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		identify: rs.identify,
+		cache:    make(map[string]forwardAuthDecision),
+	}
+
+	var err error
+	fa.decisions, err = meter.Int64Counter("tsgw.forwardauth.decisions",
+		metric.WithDescription("Forward-auth decisions by result (allow, deny, error, cached)"),
+		metric.WithUnit("{request}"))
+	if err != nil {
+		log.Warn().Err(err).Str("route", rs.RouteName).Msg("Failed to create forward-auth metric")
+	}
+	return fa
+}
+
+// middleware only lets requests approved by the authorization service through
+func (fa *forwardAuth) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		allowed, err := fa.authorize(c)
+		if err != nil || !allowed {
+			return err
+		}
+		return next(c)
+	}
+}
+
+// authorize returns true when the request may continue. Otherwise the
+// response was already written.
+func (fa *forwardAuth) authorize(c echo.Context) (bool, error) {
+	req := c.Request()
+	id := fa.identify(c)
+
+	key := fa.cacheKey(req, id)
+	if header, ok := fa.cached(key); ok {
+		fa.record(req.Context(), "cached")
+		fa.applyHeaders(req, header)
+		return true, nil
+	}
+
+	authReq, err := fa.subrequest(req, id)
+	if err != nil {
+		return false, err
+	}
+	resp, err := fa.client.Do(authReq)
+	if err != nil {
+		fa.record(req.Context(), "error")
+		log.Warn().Err(err).Str("route", fa.routeName).Msg("Forward-auth request failed")
+		return false, echo.ErrServiceUnavailable
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		fa.record(req.Context(), "deny")
+		log.Debug().Str("route", fa.routeName).Str("path", req.URL.Path).Int("status", resp.StatusCode).Msg("Forward-auth denied request")
+		// Relay the denial (e.g. a login redirect) to the client.
+		h := c.Response().Header()
+		for k, v := range resp.Header {
+			h[k] = v
+		}
+		for _, k := range forwardAuthSkipHeaders {
+			h.Del(k)
+		}
+		c.Response().WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(c.Response(), io.LimitReader(resp.Body, forwardAuthMaxBody))
+		return false, nil
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, forwardAuthMaxBody))
+
+	fa.record(req.Context(), "allow")
+	header := make(http.Header)
+	for _, name := range fa.responseHeaders {
+		if v := resp.Header.Values(name); len(v) > 0 {
+			header[http.CanonicalHeaderKey(name)] = v
+		}
+	}
+	fa.store(key, header)
+	fa.applyHeaders(req, header)
+	return true, nil
+}
+
+// subrequest builds the authorization request: the original method, no
+// body, the client headers and the forwarded request and identity details.
+func (fa *forwardAuth) subrequest(req *http.Request, id *peerIdentity) (*http.Request, error) {
+	authReq, err := http.NewRequestWithContext(req.Context(), req.Method, fa.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	authReq.Header = req.Header.Clone()
+	for _, k := range forwardAuthSkipHeaders {
+		authReq.Header.Del(k)
+	}
+	for _, k := range []string{headerTailscaleUserLogin, headerTailscaleNodeName, headerTailscaleNodeTags} {
+		authReq.Header.Del(k)
+	}
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	authReq.Header.Set("X-Forwarded-Method", req.Method)
+	authReq.Header.Set("X-Forwarded-Proto", proto)
+	authReq.Header.Set("X-Forwarded-Host", req.Host)
+	authReq.Header.Set("X-Forwarded-Uri", req.URL.RequestURI())
+	authReq.Header.Set("X-Forwarded-For", id.IP)
+	if id.Login != "" {
+		authReq.Header.Set(headerTailscaleUserLogin, id.Login)
+	}
+	if id.NodeName != "" {
+		authReq.Header.Set(headerTailscaleNodeName, id.NodeName)
+	}
+	if len(id.Tags) > 0 {
+		authReq.Header.Set(headerTailscaleNodeTags, strings.Join(id.Tags, ","))
+	}
+	return authReq, nil
+}
+
+// applyHeaders replaces the configured response headers on the request with
+// the approved values, so a client cannot supply them itself.
+func (fa *forwardAuth) applyHeaders(req *http.Request, header http.Header) {
+	for _, name := range fa.responseHeaders {
+		req.Header.Del(name)
+	}
+	for k, v := range header {
+		req.Header[k] = append([]string(nil), v...)
+	}
+}
+
+// cacheKey identifies a decision: the peer, the request target and the
+// client credentials it might depend on.
+func (fa *forwardAuth) cacheKey(req *http.Request, id *peerIdentity) string {
+	h := sha256.New()
+	for _, part := range []string{
+		id.key("node"), id.Login, req.Method, req.Host, req.URL.RequestURI(),
+		req.Header.Get("Authorization"), req.Header.Get("Cookie"),
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (fa *forwardAuth) cached(key string) (http.Header, bool) {
+	if fa.cacheTTL <= 0 {
+		return nil, false
+	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	d, ok := fa.cache[key]
+	if !ok || time.Now().After(d.expires) {
+		delete(fa.cache, key)
+		return nil, false
+	}
+	return d.header, true
+}
+
+func (fa *forwardAuth) store(key string, header http.Header) {
+	if fa.cacheTTL <= 0 {
+		return
+	}
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	if len(fa.cache) >= forwardAuthMaxEntries {
+		now := time.Now()
+		for k, d := range fa.cache {
+			if now.After(d.expires) {
+				delete(fa.cache, k)
+			}
+		}
+		if len(fa.cache) >= forwardAuthMaxEntries {
+			clear(fa.cache)
+		}
+	}
+	fa.cache[key] = forwardAuthDecision{header: header, expires: time.Now().Add(fa.cacheTTL)}
+}
+
+func (fa *forwardAuth) record(ctx context.Context, result string) {
+	if fa.decisions != nil {
+		fa.decisions.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("route.name", fa.routeName),
+			attribute.String("result", result),
+		))
+	}
+}
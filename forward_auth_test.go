@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+func newForwardAuthProxy(t *testing.T, authHandler http.HandlerFunc, cfg ForwardAuthConfig) *echo.Echo {
+	t.Helper()
+	authSrv := httptest.NewServer(authHandler)
+	t.Cleanup(authSrv.Close)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "backend:"+r.Header.Get("X-User-Id"))
+	}))
+	t.Cleanup(backend.Close)
+
+	cfg.URL = authSrv.URL
+	if cfg.Timeout == 0 {
+		cfg.Timeout = time.Second
+	}
+	rs := &RouteServer{
+		RouteName: "test",
+		Backend:   backend.URL,
+		config:    &Config{ForwardAuth: cfg},
+		whoIsFn: func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+			return &apitype.WhoIsResponse{
+				Node:        &tailcfg.Node{StableID: "n1", Name: "laptop.tail.ts.net."},
+				UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+			}, nil
+		},
+	}
+	routeProxy, err := rs.newRouteProxy()
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.Use(newForwardAuth(rs, rs.otel.meter()).middleware)
+	e.Any("/*", routeProxy.handler)
+	return e
+}
+
+func TestForwardAuth_Allow(t *testing.T) {
+	var seen http.Header
+	e := newForwardAuthProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		assert.Equal(t, http.MethodPost, r.Method)
+		w.Header().Set("X-User-Id", "42")
+		w.WriteHeader(http.StatusOK)
+	}, ForwardAuthConfig{ResponseHeaders: []string{"X-User-Id"}})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/items?x=1", strings.NewReader("body"))
+	req.Header.Set("X-User-Id", "spoofed")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "backend:42", rec.Body.String())
+	assert.Equal(t, "POST", seen.Get("X-Forwarded-Method"))
+	assert.Equal(t, "/api/items?x=1", seen.Get("X-Forwarded-Uri"))
+	assert.Equal(t, "alice@example.com", seen.Get(headerTailscaleUserLogin))
+	assert.Equal(t, "laptop.tail.ts.net", seen.Get(headerTailscaleNodeName))
+}
+
+func TestForwardAuth_Deny(t *testing.T) {
+	e := newForwardAuthProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "https://login.example.com/")
+		w.WriteHeader(http.StatusFound)
+	}, ForwardAuthConfig{})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "https://login.example.com/", rec.Header().Get("Location"))
+	assert.NotContains(t, rec.Body.String(), "backend")
+}
+
+func TestForwardAuth_Unavailable(t *testing.T) {
+	e := newForwardAuthProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}, ForwardAuthConfig{Timeout: 50 * time.Millisecond})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestForwardAuth_CachesApprovals(t *testing.T) {
+	var calls atomic.Int32
+	e := newForwardAuthProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("X-User-Id", "42")
+	}, ForwardAuthConfig{ResponseHeaders: []string{"X-User-Id"}, CacheTTL: time.Minute})
+
+	for range 3 {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/page", nil))
+		assert.Equal(t, "backend:42", rec.Body.String())
+	}
+	assert.Equal(t, int32(1), calls.Load())
+
+	// A different target is authorized separately.
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/other", nil))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestForwardAuth_RevokedAccessBypassesCache(t *testing.T) {
+	var allowed atomic.Bool
+	allowed.Store(true)
+	authSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed.Load() {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer authSrv.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = io.WriteString(w, "secret")
+	}))
+	defer backend.Close()
+
+	rs := &RouteServer{
+		RouteName: "test",
+		Backend:   backend.URL,
+		config: &Config{
+			TsnetDir:    t.TempDir(),
+			ForwardAuth: ForwardAuthConfig{URL: authSrv.URL, Timeout: time.Second},
+			Cache:       CacheConfig{Enabled: true, MaxSize: 1 << 20, MaxObjectSize: 1 << 16},
+		},
+		otel: &OpenTelemetry{},
+		whoIsFn: func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+			return &apitype.WhoIsResponse{
+				Node:        &tailcfg.Node{StableID: "n1", Name: "laptop.tail.ts.net."},
+				UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+			}, nil
+		},
+	}
+	require.NoError(t, rs.initEcho())
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rs.echo.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "https://test.tail.ts.net/page", nil))
+		return rec
+	}
+	require.Equal(t, "secret", get().Body.String())
+	cached := get()
+	require.Equal(t, "HIT", cached.Header().Get("X-Cache"))
+
+	allowed.Store(false)
+	rec := get()
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.NotContains(t, rec.Body.String(), "secret")
+}
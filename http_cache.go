@@ -0,0 +1,665 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// cachePurgePath is the reserved path of the per-route purge endpoint
+const cachePurgePath = "/.tsgw/cache/purge"
+
+// Results reported in the X-Cache header and the cache metrics
+const (
+	cacheHit         = "HIT"
+	cacheMiss        = "MISS"
+	cacheStale       = "STALE"
+	cacheRevalidated = "REVALIDATED"
+	cacheBypass      = "BYPASS"
+)
+
+// httpCache is a shared HTTP cache in front of a route backend. It follows
+// the response Cache-Control, Vary and validators, keeps hot entries in a
+// memory LRU and optionally persists them to disk.
+type httpCache struct {
+	routeName     string
+	maxObjectSize int64
+	purgeAllow    []string
+
+	// Request headers carrying the identity set by the auth middlewares.
+	// Responses to identified requests are only shared when public.
+	identityHeaders []string
+
+	mem  *memoryCache
+	disk *diskCache
+
+	refreshMu  sync.Mutex
+	refreshing map[string]bool
+
+	requests metric.Int64Counter
+}
+
+func newHTTPCache(routeName string, cfg CacheConfig, tsnetDir string, meter metric.Meter) (*httpCache, error) {
+	hc := &httpCache{
+		routeName:       routeName,
+		maxObjectSize:   cfg.MaxObjectSize,
+		purgeAllow:      cfg.PurgeAllow,
+		identityHeaders: []string{headerAuthUser, headerAuthEmail, headerAuthGroups},
+		mem:             newMemoryCache(cfg.MaxSize),
+		refreshing:      make(map[string]bool),
+	}
+
+	if cfg.DiskMaxSize > 0 {
+		// Dot-prefixed so it can never collide with a route state directory.
+		dir := filepath.Join(tsnetDir, ".cache", routeName)
+		disk, err := newDiskCache(dir, cfg.DiskMaxSize)
+		if err != nil {
+			return nil, err
+		}
+		hc.disk = disk
+	}
+
+	var err error
+	hc.requests, err = meter.Int64Counter("tsgw.cache.requests",
+		metric.WithDescription("Requests handled by the route HTTP cache, by result"),
+		metric.WithUnit("{request}"))
+	if err != nil {
+		log.Warn().Err(err).Str("route", routeName).Msg("Failed to create cache metric")
+	}
+
+	return hc, nil
+}
+
+func (hc *httpCache) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		if !cacheableRequest(req) {
+			hc.count(req.Context(), cacheBypass)
+			return next(c)
+		}
+
+		now := time.Now()
+		reqCC := parseCacheControl(req.Header.Get("Cache-Control"))
+		forceRevalidate := reqCC.has("no-cache") || reqCC["max-age"] == "0" || req.Header.Get("Pragma") == "no-cache"
+
+		entry := hc.lookupFor(req)
+		addedValidators := false
+		if entry != nil && !forceRevalidate {
+			if now.Before(entry.Expires) {
+				hc.count(req.Context(), cacheHit)
+				return hc.serve(c.Response(), req, entry, cacheHit)
+			}
+			if now.Before(entry.Expires.Add(entry.StaleWhile)) {
+				hc.count(req.Context(), cacheStale)
+				hc.revalidateAsync(c.Echo(), next, req, entry)
+				return hc.serve(c.Response(), req, entry, cacheStale)
+			}
+		}
+		if entry != nil && req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+			addedValidators = setValidators(req, entry)
+		}
+
+		res := c.Response()
+		res.Header().Set("X-Cache", cacheMiss)
+		orig := res.Writer
+		cw := &cacheWriter{ResponseWriter: orig, limit: hc.maxObjectSize, swallowNotModified: addedValidators}
+		res.Writer = cw
+		err := next(c)
+		res.Writer = orig
+
+		if addedValidators {
+			// Don't answer the client with a 304 it never asked for.
+			req.Header.Del("If-None-Match")
+			req.Header.Del("If-Modified-Since")
+		}
+		if cw.notModified {
+			refreshed := refreshEntry(entry, cw.Header(), time.Now())
+			hc.store(req, refreshed)
+			hc.count(req.Context(), cacheRevalidated)
+			// The proxy already committed the Echo response for the 304.
+			res.Status = refreshed.Status
+			return hc.serve(orig, req, refreshed, cacheRevalidated)
+		}
+
+		hc.count(req.Context(), cacheMiss)
+		if err == nil {
+			var e *cacheEntry
+			if !cw.overflow {
+				e = newCacheEntry(req.URL.RequestURI(), req, cw.status, cw.Header(), cw.body.Bytes(), time.Now())
+			}
+			switch {
+			case e != nil:
+				hc.store(req, e)
+			case entry != nil && cw.status < http.StatusInternalServerError:
+				hc.forget(req)
+			}
+		}
+		return err
+	}
+}
+
+// purgeHandler drops cached entries for a path prefix (or everything when no
+// path is given). Only peers listed in cache-purge-allow may call it.
+func (hc *httpCache) purgeHandler(identify func(echo.Context) *peerIdentity) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id := identify(c)
+		if !identityAllowed(id, hc.purgeAllow) {
+			return echo.ErrForbidden
+		}
+
+		prefix := c.QueryParam("path")
+		n := hc.mem.purge(prefix)
+		if hc.disk != nil {
+			if dn := hc.disk.purge(prefix); dn > n {
+				n = dn
+			}
+		}
+		log.Info().Str("route", hc.routeName).Str("prefix", prefix).Str("by", id.key("user")).Int("entries", n).Msg("Cache purged")
+		return c.JSON(http.StatusOK, map[string]int{"purged": n})
+	}
+}
+
+// lookupFor returns the entry for the request: the requester's own copy, or
+// a public one when the request carries an identity.
+func (hc *httpCache) lookupFor(req *http.Request) *cacheEntry {
+	shared := req.URL.RequestURI()
+	id := hc.identity(req)
+	if id == "" {
+		return hc.lookup(shared, req)
+	}
+	if e := hc.lookup(identityKey(shared, id), req); e != nil {
+		return e
+	}
+	if e := hc.lookup(shared, req); e != nil && parseCacheControl(e.Header.Get("Cache-Control")).has("public") {
+		return e
+	}
+	return nil
+}
+
+// identity returns a digest of the identity headers set on the request, or
+// an empty string for anonymous requests.
+func (hc *httpCache) identity(req *http.Request) string {
+	h := sha256.New()
+	found := false
+	for _, name := range hc.identityHeaders {
+		values := req.Header.Values(name)
+		if len(values) > 0 {
+			found = true
+		}
+		h.Write([]byte(name + ":" + strings.Join(values, ",") + "\n"))
+	}
+	if !found {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// identityKey keeps the request URI first so purging by path prefix still
+// reaches per-identity entries.
+func identityKey(primary, id string) string {
+	return primary + "\nidentity:" + id
+}
+
+// lookup returns the stored entry matching the request, following Vary markers
+func (hc *httpCache) lookup(key string, req *http.Request) *cacheEntry {
+	e := hc.get(key)
+	if e != nil && e.VaryMarker {
+		e = hc.get(varyKey(key, e.VaryNames, req))
+	}
+	return e
+}
+
+func (hc *httpCache) get(key string) *cacheEntry {
+	if e := hc.mem.get(key); e != nil {
+		return e
+	}
+	if hc.disk == nil {
+		return nil
+	}
+	e := hc.disk.get(key)
+	if e != nil {
+		hc.mem.set(e)
+	}
+	return e
+}
+
+// store saves the entry, adding a Vary marker under the primary key when the
+// response varies on request headers.
+func (hc *httpCache) store(req *http.Request, e *cacheEntry) {
+	primary := req.URL.RequestURI()
+	if id := hc.identity(req); id != "" && !parseCacheControl(e.Header.Get("Cache-Control")).has("public") {
+		primary = identityKey(primary, id)
+	}
+	if len(e.VaryNames) > 0 {
+		marker := &cacheEntry{Key: primary, VaryNames: e.VaryNames, VaryMarker: true}
+		hc.set(marker)
+		e.Key = varyKey(primary, e.VaryNames, req)
+	} else {
+		e.Key = primary
+	}
+	hc.set(e)
+}
+
+// forget drops the entries the request would be served from once the
+// backend answered with a response that cannot be stored in their place.
+// Server errors keep the old entries.
+func (hc *httpCache) forget(req *http.Request) {
+	primaries := []string{req.URL.RequestURI()}
+	if id := hc.identity(req); id != "" {
+		primaries = append(primaries, identityKey(primaries[0], id))
+	}
+	for _, primary := range primaries {
+		if marker := hc.get(primary); marker != nil && marker.VaryMarker {
+			hc.delete(varyKey(primary, marker.VaryNames, req))
+		}
+		hc.delete(primary)
+	}
+}
+
+func (hc *httpCache) delete(key string) {
+	hc.mem.delete(key)
+	if hc.disk != nil {
+		hc.disk.delete(key)
+	}
+}
+
+func (hc *httpCache) set(e *cacheEntry) {
+	hc.mem.set(e)
+	if hc.disk != nil {
+		hc.disk.set(e)
+	}
+}
+
+// revalidateAsync refreshes a stale entry in the background while the stale
+// copy is served (stale-while-revalidate). Only one refresh runs per key.
+func (hc *httpCache) revalidateAsync(e *echo.Echo, next echo.HandlerFunc, req *http.Request, entry *cacheEntry) {
+	key := entry.Key
+	hc.refreshMu.Lock()
+	if hc.refreshing[key] {
+		hc.refreshMu.Unlock()
+		return
+	}
+	hc.refreshing[key] = true
+	hc.refreshMu.Unlock()
+
+	bgReq := req.Clone(context.WithoutCancel(req.Context()))
+	bgReq.Header.Del("If-None-Match")
+	bgReq.Header.Del("If-Modified-Since")
+	setValidators(bgReq, entry)
+
+	go func() {
+		defer func() {
+			hc.refreshMu.Lock()
+			delete(hc.refreshing, key)
+			hc.refreshMu.Unlock()
+		}()
+
+		w := &cacheWriter{ResponseWriter: discardResponseWriter{header: http.Header{}}, limit: hc.maxObjectSize, swallowNotModified: true}
+		if err := next(e.NewContext(bgReq, w)); err != nil {
+			log.Debug().Err(err).Str("route", hc.routeName).Str("key", key).Msg("Background cache revalidation failed")
+			return
+		}
+		now := time.Now()
+		if w.notModified {
+			hc.store(bgReq, refreshEntry(entry, w.Header(), now))
+			return
+		}
+		var fresh *cacheEntry
+		if !w.overflow {
+			fresh = newCacheEntry(key, bgReq, w.status, w.Header(), w.body.Bytes(), now)
+		}
+		switch {
+		case fresh != nil:
+			hc.store(bgReq, fresh)
+		case w.status < http.StatusInternalServerError:
+			// The stale copy must not keep being served and refreshed.
+			hc.forget(bgReq)
+		}
+	}()
+}
+
+// serve writes a cached entry to the client, answering conditional requests
+// with 304 when the stored validators match.
+func (hc *httpCache) serve(w http.ResponseWriter, req *http.Request, e *cacheEntry, result string) error {
+	h := w.Header()
+	for k := range h {
+		delete(h, k)
+	}
+	for k, vs := range e.Header {
+		h[k] = append([]string(nil), vs...)
+	}
+	h.Set("Age", strconv.Itoa(int(time.Since(e.Stored).Seconds())))
+	h.Set("X-Cache", result)
+
+	if notModifiedFor(req, e) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	h.Set("Content-Length", strconv.Itoa(len(e.Body)))
+	w.WriteHeader(e.Status)
+	if req.Method != http.MethodHead {
+		_, _ = w.Write(e.Body)
+	}
+	return nil
+}
+
+func (hc *httpCache) count(ctx context.Context, result string) {
+	if hc.requests == nil {
+		return
+	}
+	hc.requests.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("route.name", hc.routeName),
+		attribute.String("cache.result", strings.ToLower(result)),
+	))
+}
+
+// cacheableRequest reports whether the request may be answered from cache
+func cacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Header.Get("Range") != "" || req.Header.Get("Upgrade") != "" {
+		return false
+	}
+	return !parseCacheControl(req.Header.Get("Cache-Control")).has("no-store")
+}
+
+// newCacheEntry builds an entry from a backend response, or returns nil when
+// a shared cache must not store it.
+func newCacheEntry(key string, req *http.Request, status int, header http.Header, body []byte, now time.Time) *cacheEntry {
+	if req.Method != http.MethodGet {
+		return nil
+	}
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent, http.StatusMultipleChoices,
+		http.StatusMovedPermanently, http.StatusPermanentRedirect, http.StatusNotFound, http.StatusGone:
+	default:
+		return nil
+	}
+
+	cc := parseCacheControl(header.Get("Cache-Control"))
+	if cc.has("no-store") || cc.has("private") || header.Get("Set-Cookie") != "" {
+		return nil
+	}
+	if strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		return nil
+	}
+	if req.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return nil
+	}
+
+	varyNames, ok := parseVary(header)
+	if !ok {
+		return nil
+	}
+
+	lifetime := freshnessLifetime(cc, header, now)
+	if lifetime <= 0 && header.Get("ETag") == "" && header.Get("Last-Modified") == "" {
+		return nil
+	}
+
+	e := &cacheEntry{
+		Key:       key,
+		Status:    status,
+		Header:    storableHeader(header),
+		Body:      append([]byte(nil), body...),
+		Stored:    now,
+		Expires:   now.Add(lifetime),
+		VaryNames: varyNames,
+	}
+	if swr, err := strconv.Atoi(cc["stale-while-revalidate"]); err == nil && swr > 0 && !cc.has("must-revalidate") {
+		e.StaleWhile = time.Duration(swr) * time.Second
+	}
+	return e
+}
+
+// refreshEntry returns a copy of entry updated with the headers of a 304
+func refreshEntry(entry *cacheEntry, header http.Header, now time.Time) *cacheEntry {
+	refreshed := *entry
+	refreshed.Header = entry.Header.Clone()
+	for k, vs := range storableHeader(header) {
+		if k == "Content-Length" {
+			continue
+		}
+		refreshed.Header[k] = vs
+	}
+	cc := parseCacheControl(refreshed.Header.Get("Cache-Control"))
+	refreshed.Stored = now
+	refreshed.Expires = now.Add(freshnessLifetime(cc, refreshed.Header, now))
+	return &refreshed
+}
+
+// freshnessLifetime follows s-maxage, max-age and Expires, in that order
+func freshnessLifetime(cc cacheControl, header http.Header, now time.Time) time.Duration {
+	if cc.has("no-cache") {
+		return 0
+	}
+	for _, directive := range []string{"s-maxage", "max-age"} {
+		if v, ok := cc[directive]; ok {
+			secs, err := strconv.Atoi(v)
+			if err != nil {
+				return 0
+			}
+			age, _ := strconv.Atoi(header.Get("Age"))
+			return time.Duration(secs-age) * time.Second
+		}
+	}
+	if v := header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			return 0
+		}
+		date := now
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+		return expires.Sub(date)
+	}
+	return 0
+}
+
+func setValidators(req *http.Request, e *cacheEntry) bool {
+	added := false
+	if etag := e.Header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+		added = true
+	}
+	if lm := e.Header.Get("Last-Modified"); lm != "" {
+		req.Header.Set("If-Modified-Since", lm)
+		added = true
+	}
+	return added
+}
+
+func notModifiedFor(req *http.Request, e *cacheEntry) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := e.Header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims, err := http.ParseTime(req.Header.Get("If-Modified-Since")); err == nil {
+		if lm, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil {
+			return !lm.After(ims)
+		}
+	}
+	return false
+}
+
+// parseVary returns the canonical header names a response varies on; ok is
+// false for "Vary: *", which can never be served from cache.
+func parseVary(header http.Header) (names []string, ok bool) {
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+func varyKey(primary string, names []string, req *http.Request) string {
+	var b strings.Builder
+	b.WriteString(primary)
+	for _, name := range names {
+		b.WriteString("\n")
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// hopHeaders are connection-specific and never stored
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "X-Cache", "Age"}
+
+func storableHeader(header http.Header) http.Header {
+	h := header.Clone()
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+	return h
+}
+
+type cacheControl map[string]string
+
+func parseCacheControl(v string) cacheControl {
+	cc := cacheControl{}
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(part, "=")
+		cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+	return cc
+}
+
+func (cc cacheControl) has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// identityAllowed reports whether the peer matches one of the allowed user
+// logins, tags or node names.
+func identityAllowed(id *peerIdentity, allowed []string) bool {
+	for _, a := range allowed {
+		if a == "" {
+			continue
+		}
+		if a == id.Login || a == id.NodeName {
+			return true
+		}
+		for _, tag := range id.Tags {
+			if a == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheWriter passes the response through to the client while keeping a copy
+// of the body (up to limit) for the cache. When the cache itself added the
+// validators, a 304 is swallowed so the stored entry can be served instead.
+type cacheWriter struct {
+	http.ResponseWriter
+	limit              int64
+	swallowNotModified bool
+
+	status      int
+	wroteHeader bool
+	notModified bool
+	overflow    bool
+	body        bytes.Buffer
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = code
+	if code == http.StatusNotModified && w.swallowNotModified {
+		w.notModified = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notModified {
+		return len(b), nil
+	}
+	if !w.overflow {
+		if int64(w.body.Len()+len(b)) > w.limit {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheWriter) Flush() {
+	if w.notModified {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *cacheWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijacking not supported")
+}
+
+func (w *cacheWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// discardResponseWriter is the sink for background revalidation requests
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w discardResponseWriter) Header() http.Header         { return w.header }
+func (w discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w discardResponseWriter) WriteHeader(int)             {}
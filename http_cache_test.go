@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+func newTestCache(t *testing.T, cfg CacheConfig) *httpCache {
+	t.Helper()
+	if cfg.MaxSize == 0 {
+		cfg.MaxSize = 1 << 20
+	}
+	if cfg.MaxObjectSize == 0 {
+		cfg.MaxObjectSize = 1 << 16
+	}
+	hc, err := newHTTPCache("test", cfg, t.TempDir(), noop.NewMeterProvider().Meter("test"))
+	require.NoError(t, err)
+	return hc
+}
+
+func newCachedEcho(hc *httpCache, handler echo.HandlerFunc) *echo.Echo {
+	e := echo.New()
+	e.Use(hc.middleware)
+	e.Any("/*", handler)
+	return e
+}
+
+func doCacheRequest(e *echo.Echo, method, path string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHTTPCache_HitAfterMiss(t *testing.T) {
+	var calls atomic.Int32
+	e := newCachedEcho(newTestCache(t, CacheConfig{}), func(c echo.Context) error {
+		calls.Add(1)
+		c.Response().Header().Set("Cache-Control", "max-age=60")
+		return c.String(http.StatusOK, "hello")
+	})
+
+	first := doCacheRequest(e, http.MethodGet, "/page", nil)
+	assert.Equal(t, "MISS", first.Header().Get("X-Cache"))
+
+	second := doCacheRequest(e, http.MethodGet, "/page", nil)
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	assert.Equal(t, "hello", second.Body.String())
+
+	head := doCacheRequest(e, http.MethodHead, "/page", nil)
+	assert.Equal(t, "HIT", head.Header().Get("X-Cache"))
+	assert.Empty(t, head.Body.String())
+
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestHTTPCache_NotStored(t *testing.T) {
+	tests := []struct {
+		name   string
+		header map[string]string
+	}{
+		{name: "no-store", header: map[string]string{"Cache-Control": "no-store, max-age=60"}},
+		{name: "private", header: map[string]string{"Cache-Control": "private, max-age=60"}},
+		{name: "set-cookie", header: map[string]string{"Cache-Control": "max-age=60", "Set-Cookie": "a=b"}},
+		{name: "vary star", header: map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}},
+		{name: "no freshness or validators", header: map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			e := newCachedEcho(newTestCache(t, CacheConfig{}), func(c echo.Context) error {
+				calls.Add(1)
+				for k, v := range tt.header {
+					c.Response().Header().Set(k, v)
+				}
+				return c.String(http.StatusOK, "hello")
+			})
+
+			doCacheRequest(e, http.MethodGet, "/", nil)
+			doCacheRequest(e, http.MethodGet, "/", nil)
+			assert.Equal(t, int32(2), calls.Load())
+		})
+	}
+}
+
+func TestHTTPCache_Vary(t *testing.T) {
+	var calls atomic.Int32
+	e := newCachedEcho(newTestCache(t, CacheConfig{}), func(c echo.Context) error {
+		calls.Add(1)
+		c.Response().Header().Set("Cache-Control", "max-age=60")
+		c.Response().Header().Set("Vary", "Accept-Language")
+		return c.String(http.StatusOK, c.Request().Header.Get("Accept-Language"))
+	})
+
+	en := map[string]string{"Accept-Language": "en"}
+	pt := map[string]string{"Accept-Language": "pt"}
+
+	doCacheRequest(e, http.MethodGet, "/", en)
+	doCacheRequest(e, http.MethodGet, "/", pt)
+	rec := doCacheRequest(e, http.MethodGet, "/", pt)
+
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "pt", rec.Body.String())
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestHTTPCache_Revalidation(t *testing.T) {
+	var calls, notModified atomic.Int32
+	e := newCachedEcho(newTestCache(t, CacheConfig{}), func(c echo.Context) error {
+		calls.Add(1)
+		c.Response().Header().Set("Cache-Control", "no-cache")
+		c.Response().Header().Set("ETag", `"v1"`)
+		if c.Request().Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			return c.NoContent(http.StatusNotModified)
+		}
+		return c.String(http.StatusOK, "body")
+	})
+
+	doCacheRequest(e, http.MethodGet, "/", nil)
+	rec := doCacheRequest(e, http.MethodGet, "/", nil)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "REVALIDATED", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "body", rec.Body.String())
+	assert.Equal(t, int32(2), calls.Load())
+	assert.Equal(t, int32(1), notModified.Load())
+
+	// A client conditional request is answered from cache.
+	rec = doCacheRequest(e, http.MethodGet, "/", map[string]string{"If-None-Match": `"v1"`})
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+}
+
+func TestHTTPCache_StaleWhileRevalidate(t *testing.T) {
+	var calls atomic.Int32
+	hc := newTestCache(t, CacheConfig{})
+	e := newCachedEcho(hc, func(c echo.Context) error {
+		calls.Add(1)
+		c.Response().Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+		c.Response().Header().Set("ETag", `"v1"`)
+		return c.String(http.StatusOK, "body")
+	})
+
+	doCacheRequest(e, http.MethodGet, "/", nil)
+	rec := doCacheRequest(e, http.MethodGet, "/", nil)
+
+	assert.Equal(t, "STALE", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "body", rec.Body.String())
+	assert.Eventually(t, func() bool { return calls.Load() == 2 }, time.Second, 5*time.Millisecond)
+}
+
+func TestHTTPCache_RefreshNotStorable(t *testing.T) {
+	for _, refresh := range []string{"no-store", "oversized"} {
+		t.Run(refresh, func(t *testing.T) {
+			var calls atomic.Int32
+			hc := newTestCache(t, CacheConfig{MaxObjectSize: 1024, DiskMaxSize: 1 << 20})
+			e := newCachedEcho(hc, func(c echo.Context) error {
+				if calls.Add(1) == 1 {
+					c.Response().Header().Set("Cache-Control", "max-age=0, stale-while-revalidate=60")
+					c.Response().Header().Set("ETag", `"v1"`)
+					return c.String(http.StatusOK, "old")
+				}
+				if refresh == "no-store" {
+					c.Response().Header().Set("Cache-Control", "no-store")
+					return c.String(http.StatusOK, "new")
+				}
+				c.Response().Header().Set("Cache-Control", "max-age=60")
+				return c.String(http.StatusOK, strings.Repeat("n", 2048))
+			})
+
+			doCacheRequest(e, http.MethodGet, "/", nil)
+			rec := doCacheRequest(e, http.MethodGet, "/", nil)
+			assert.Equal(t, "STALE", rec.Header().Get("X-Cache"))
+
+			// The outdated entry is dropped from memory and disk once the
+			// refresh cannot replace it.
+			require.Eventually(t, func() bool { return hc.mem.get("/") == nil && hc.disk.get("/") == nil }, time.Second, 5*time.Millisecond)
+			rec = doCacheRequest(e, http.MethodGet, "/", nil)
+			assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+			assert.Equal(t, int32(3), calls.Load())
+		})
+	}
+}
+
+func TestHTTPCache_RevalidationNotStorable(t *testing.T) {
+	var calls atomic.Int32
+	hc := newTestCache(t, CacheConfig{})
+	e := newCachedEcho(hc, func(c echo.Context) error {
+		if calls.Add(1) == 1 {
+			c.Response().Header().Set("Cache-Control", "no-cache")
+			c.Response().Header().Set("ETag", `"v1"`)
+			return c.String(http.StatusOK, "old")
+		}
+		c.Response().Header().Set("Cache-Control", "private")
+		return c.String(http.StatusOK, "new")
+	})
+
+	doCacheRequest(e, http.MethodGet, "/", nil)
+	rec := doCacheRequest(e, http.MethodGet, "/", nil)
+	assert.Equal(t, "new", rec.Body.String())
+	assert.Nil(t, hc.mem.get("/"))
+}
+
+func TestMemoryCache_OversizedReplacesEntry(t *testing.T) {
+	m := newMemoryCache(64)
+	m.set(&cacheEntry{Key: "/", Body: []byte("old")})
+	m.set(&cacheEntry{Key: "/", Body: make([]byte, 128)})
+	assert.Nil(t, m.get("/"))
+	assert.Zero(t, m.size)
+}
+
+func TestHTTPCache_DiskStore(t *testing.T) {
+	dir := t.TempDir()
+	cfg := CacheConfig{MaxSize: 1 << 20, MaxObjectSize: 1 << 16, DiskMaxSize: 1 << 20}
+	meter := noop.NewMeterProvider().Meter("test")
+
+	hc, err := newHTTPCache("test", cfg, dir, meter)
+	require.NoError(t, err)
+	e := newCachedEcho(hc, func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", "max-age=60")
+		return c.String(http.StatusOK, "persisted")
+	})
+	doCacheRequest(e, http.MethodGet, "/file", nil)
+
+	// A fresh cache instance (e.g. after a restart) finds the entry on disk.
+	hc2, err := newHTTPCache("test", cfg, dir, meter)
+	require.NoError(t, err)
+	e2 := newCachedEcho(hc2, func(c echo.Context) error {
+		t.Fatal("backend must not be called")
+		return nil
+	})
+	rec := doCacheRequest(e2, http.MethodGet, "/file", nil)
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "persisted", rec.Body.String())
+}
+
+func TestHTTPCache_Purge(t *testing.T) {
+	hc := newTestCache(t, CacheConfig{PurgeAllow: []string{"tag:ops"}})
+	rs := &RouteServer{
+		RouteName: "test",
+		whoIsFn: func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+			if remoteAddr == "100.64.0.1:1234" {
+				return &apitype.WhoIsResponse{Node: &tailcfg.Node{StableID: "n1", Tags: []string{"tag:ops"}}}, nil
+			}
+			return &apitype.WhoIsResponse{UserProfile: &tailcfg.UserProfile{LoginName: "bob@example.com"}}, nil
+		},
+	}
+
+	e := newCachedEcho(hc, func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", "max-age=60")
+		return c.String(http.StatusOK, "x")
+	})
+	e.POST(cachePurgePath, hc.purgeHandler(rs.identify))
+	doCacheRequest(e, http.MethodGet, "/a", nil)
+
+	purge := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, cachePurgePath+"?path=/a", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusForbidden, purge("100.64.0.2:1234").Code)
+	rec := purge("100.64.0.1:1234")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"purged":1}`, rec.Body.String())
+
+	assert.Equal(t, "MISS", doCacheRequest(e, http.MethodGet, "/a", nil).Header().Get("X-Cache"))
+}
+
+func TestHTTPCache_SeparatesIdentities(t *testing.T) {
+	var calls atomic.Int32
+	e := newCachedEcho(newTestCache(t, CacheConfig{}), func(c echo.Context) error {
+		calls.Add(1)
+		if c.Request().URL.Path == "/logo" {
+			c.Response().Header().Set("Cache-Control", "public, max-age=60")
+			return c.String(http.StatusOK, "logo")
+		}
+		c.Response().Header().Set("Cache-Control", "max-age=60")
+		return c.String(http.StatusOK, "hello "+c.Request().Header.Get(headerAuthUser))
+	})
+	alice := map[string]string{headerAuthUser: "alice"}
+	bob := map[string]string{headerAuthUser: "bob"}
+
+	assert.Equal(t, "hello alice", doCacheRequest(e, http.MethodGet, "/me", alice).Body.String())
+	rec := doCacheRequest(e, http.MethodGet, "/me", bob)
+	assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "hello bob", rec.Body.String())
+	rec = doCacheRequest(e, http.MethodGet, "/me", alice)
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "hello alice", rec.Body.String())
+	rec = doCacheRequest(e, http.MethodGet, "/me", nil)
+	assert.Equal(t, "MISS", rec.Header().Get("X-Cache"), "anonymous requests never get a personal copy")
+
+	// Public responses are shared by everyone.
+	doCacheRequest(e, http.MethodGet, "/logo", alice)
+	assert.Equal(t, "HIT", doCacheRequest(e, http.MethodGet, "/logo", bob).Header().Get("X-Cache"))
+	assert.Equal(t, "HIT", doCacheRequest(e, http.MethodGet, "/logo", nil).Header().Get("X-Cache"))
+	assert.Equal(t, int32(4), calls.Load())
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// identityContextKey stores the resolved peerIdentity on the Echo context so
+// every middleware of a request shares a single WhoIs lookup.
+const identityContextKey = "tsgw.identity"
+
+// whoIsFunc resolves the Tailscale identity behind a remote address
+type whoIsFunc func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+
+// peerIdentity is the client behind a request. Only IP is set when the peer
+// is not a tailnet node (e.g. Funnel traffic) or WhoIs fails.
+type peerIdentity struct {
+	IP       string
+	NodeID   string
+	NodeName string
+	Login    string
+	Tags     []string
+}
+
+// key returns the identifier of the peer for the given kind (node, user, tag
+// or ip), falling back to the client IP when the identity is unknown.
+func (id *peerIdentity) key(kind string) string {
+	switch kind {
+	case "node":
+		if id.NodeID != "" {
+			return "node:" + id.NodeID
+		}
+	case "user":
+		if id.Login != "" {
+			return "user:" + id.Login
+		}
+	case "tag":
+		if len(id.Tags) > 0 {
+			return strings.Join(id.Tags, ",")
+		}
+	}
+	return "ip:" + id.IP
+}
+
+func validateIdentityKey(kind string) error {
+	switch kind {
+	case "node", "user", "tag", "ip":
+		return nil
+	}
+	return fmt.Errorf("invalid identity key %q (valid: node, user, tag, ip)", kind)
+}
+
+// identify resolves the identity of the client behind the request
+func (rs *RouteServer) identify(c echo.Context) *peerIdentity {
+	if id, ok := c.Get(identityContextKey).(*peerIdentity); ok {
+		return id
+	}
+
+	req := c.Request()
+	id := &peerIdentity{IP: req.RemoteAddr}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		id.IP = host
+	}
+
+	who, err := rs.whoIs(req.Context(), req.RemoteAddr)
+	if err != nil {
+		log.Debug().Err(err).Str("route", rs.RouteName).Str("remote", req.RemoteAddr).Msg("WhoIs lookup failed; using client IP")
+	} else {
+		if who.Node != nil {
+			id.NodeID = string(who.Node.StableID)
+			id.NodeName = strings.TrimSuffix(who.Node.Name, ".")
+			id.Tags = who.Node.Tags
+		}
+		if who.UserProfile != nil && len(id.Tags) == 0 {
+			id.Login = who.UserProfile.LoginName
+		}
+	}
+
+	c.Set(identityContextKey, id)
+	return id
+}
+
+// whoIs queries the route's tsnet node for the identity behind remoteAddr
+func (rs *RouteServer) whoIs(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+	if rs.whoIsFn != nil {
+		return rs.whoIsFn(ctx, remoteAddr)
+	}
+	lc, err := rs.Server.LocalClient()
+	if err != nil {
+		return nil, err
+	}
+	return lc.WhoIs(ctx, remoteAddr)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"tailscale.com/client/tailscale/v2"
+	"tailscale.com/ipn/ipnstate"
+)
+
+// keyExpiryCheckInterval is how often a route checks its node key expiry
+const keyExpiryCheckInterval = time.Hour
+
+// nodeStatusClient is the part of the LocalAPI client the node monitors use
+type nodeStatusClient interface {
+	StatusWithoutPeers(ctx context.Context) (*ipnstate.Status, error)
+}
+
+// keyExpiryMonitor reports the time left before a route node key expires,
+// warns ahead of time and disables expiry or re-authenticates the node when
+// configured to.
+type keyExpiryMonitor struct {
+	routeName string
+	cfg       KeyExpiryConfig
+	lc        nodeStatusClient
+	tsClient  *tailscale.Client
+	renew     func(ctx context.Context) error
+
+	expiryDisabled bool
+
+	remaining metric.Float64Gauge
+}
+
+func newKeyExpiryMonitor(route *RouteConfig, lc nodeStatusClient, tsClient *tailscale.Client, renew func(ctx context.Context) error, meter metric.Meter) *keyExpiryMonitor {
+	m := &keyExpiryMonitor{
+		routeName: route.Name,
+		cfg:       route.KeyExpiry,
+		lc:        lc,
+		tsClient:  tsClient,
+		renew:     renew,
+	}
+
+	var err error
+	m.remaining, err = meter.Float64Gauge("tsgw.node.key_expiry",
+		metric.WithDescription("Time left before the route node key expires"),
+		metric.WithUnit("s"))
+	if err != nil {
+		log.Warn().Err(err).Str("route", route.Name).Msg("Failed to create key expiry metric")
+	}
+	return m
+}
+
+// run checks the key expiry right away and then every interval until ctx is
+// done.
+func (m *keyExpiryMonitor) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *keyExpiryMonitor) check(ctx context.Context) {
+	st, err := m.lc.StatusWithoutPeers(ctx)
+	if err != nil || st.Self == nil {
+		log.Debug().Err(err).Str("route", m.routeName).Msg("Failed to get node status for key expiry check")
+		return
+	}
+	if st.Self.KeyExpiry == nil {
+		log.Debug().Str("route", m.routeName).Msg("Node key expiry is disabled")
+		return
+	}
+
+	expiry := *st.Self.KeyExpiry
+	remaining := time.Until(expiry)
+	if m.remaining != nil {
+		m.remaining.Record(ctx, remaining.Seconds(), metric.WithAttributes(attribute.String("route.name", m.routeName)))
+	}
+	logger := log.With().Str("route", m.routeName).Time("key_expiry", expiry).Dur("remaining", remaining).Logger()
+
+	if m.cfg.Disable && m.tsClient != nil {
+		// The node status may still show the expiry for a while afterwards.
+		if m.expiryDisabled {
+			return
+		}
+		err := m.tsClient.Devices().SetKey(ctx, string(st.Self.ID), tailscale.DeviceKey{KeyExpiryDisabled: true})
+		if err == nil {
+			m.expiryDisabled = true
+			logger.Info().Msg("Disabled node key expiry")
+			return
+		}
+		logger.Warn().Err(err).Msg("Failed to disable node key expiry")
+	}
+
+	if m.cfg.RenewBefore > 0 && remaining < m.cfg.RenewBefore && m.renew != nil {
+		logger.Info().Msg("Node key expires soon; re-authenticating")
+		if err := m.renew(ctx); err != nil {
+			logger.Warn().Err(err).Msg("Failed to re-authenticate node")
+		}
+		return
+	}
+
+	if remaining < m.cfg.Warning {
+		logger.Warn().Msg("Node key expires soon; the route will go offline when it does")
+	}
+}
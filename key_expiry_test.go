@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/metric/noop"
+	"tailscale.com/ipn/ipnstate"
+)
+
+// fakeNodeStatus serves the node status returned by the function
+type fakeNodeStatus func() *ipnstate.Status
+
+func (f fakeNodeStatus) StatusWithoutPeers(context.Context) (*ipnstate.Status, error) {
+	return f(), nil
+}
+
+func nodeExpiringIn(d time.Duration) fakeNodeStatus {
+	expiry := time.Now().Add(d)
+	return func() *ipnstate.Status {
+		return &ipnstate.Status{BackendState: "Running", Self: &ipnstate.PeerStatus{ID: "nABC123", KeyExpiry: &expiry}}
+	}
+}
+
+func TestKeyExpiryMonitor_Renew(t *testing.T) {
+	route := &RouteConfig{Name: "app", KeyExpiry: KeyExpiryConfig{Warning: 7 * 24 * time.Hour, RenewBefore: 24 * time.Hour}}
+	renewed := 0
+	renew := func(context.Context) error { renewed++; return nil }
+
+	newKeyExpiryMonitor(route, nodeExpiringIn(30*24*time.Hour), nil, renew, noop.NewMeterProvider().Meter("test")).check(context.Background())
+	assert.Equal(t, 0, renewed)
+
+	newKeyExpiryMonitor(route, nodeExpiringIn(time.Hour), nil, renew, noop.NewMeterProvider().Meter("test")).check(context.Background())
+	assert.Equal(t, 1, renewed)
+
+	// Nodes without key expiry are left alone.
+	disabled := fakeNodeStatus(func() *ipnstate.Status { return &ipnstate.Status{Self: &ipnstate.PeerStatus{ID: "nABC123"}} })
+	newKeyExpiryMonitor(route, disabled, nil, renew, noop.NewMeterProvider().Meter("test")).check(context.Background())
+	assert.Equal(t, 1, renewed)
+}
+
+func TestKeyExpiryMonitor_Disable(t *testing.T) {
+	var calls []string
+	client := newFakeTailscaleAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+	})
+	route := &RouteConfig{Name: "app", KeyExpiry: KeyExpiryConfig{Disable: true, RenewBefore: 24 * time.Hour}}
+	renewed := 0
+	m := newKeyExpiryMonitor(route, nodeExpiringIn(time.Hour), client, func(context.Context) error { renewed++; return nil }, noop.NewMeterProvider().Meter("test"))
+
+	m.check(context.Background())
+	m.check(context.Background())
+
+	// Expiry is only disabled once, and then no renewal is needed.
+	assert.Equal(t, []string{"POST /api/v2/device/nABC123/key"}, calls)
+	assert.Equal(t, 0, renewed)
+}
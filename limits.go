@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+var errSlowClient = errors.New("request body is arriving below the minimum transfer rate")
+
+// clientBodyError marks a failure reading the client request body, so the
+// proxy error handler answers 413/408 instead of blaming the backend.
+type clientBodyError struct {
+	err error
+}
+
+func (e *clientBodyError) Error() string { return e.err.Error() }
+func (e *clientBodyError) Unwrap() error { return e.err }
+
+// clientBodyKey stores the request's guardedBody in its context. A failed
+// body read also cancels the request context, so the proxy may report
+// "context canceled" instead of the read error itself.
+type clientBodyKey struct{}
+
+// requestLimitsMiddleware enforces the route body size cap and minimum upload
+// rate before the request reaches the backend.
+func (rs *RouteServer) requestLimitsMiddleware() echo.MiddlewareFunc {
+	cfg := rs.route.Limits
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if cfg.MaxBodySize > 0 && req.ContentLength > cfg.MaxBodySize {
+				return echo.ErrStatusRequestEntityTooLarge
+			}
+			if req.Body == nil || req.Body == http.NoBody {
+				return next(c)
+			}
+
+			body := req.Body
+			if cfg.MaxBodySize > 0 {
+				body = http.MaxBytesReader(c.Response(), body, cfg.MaxBodySize)
+			}
+			guarded := &guardedBody{
+				ReadCloser: body,
+				rc:         http.NewResponseController(c.Response()),
+				minRate:    cfg.MinTransferRate,
+				grace:      cfg.MinTransferRateGrace,
+			}
+			req.Body = guarded
+			c.SetRequest(req.WithContext(context.WithValue(req.Context(), clientBodyKey{}, guarded)))
+			return next(c)
+		}
+	}
+}
+
+// guardedBody tags client read errors and enforces the minimum transfer
+// rate. Each read extends the connection read deadline by the grace period,
+// so a client that stops sending entirely also times out.
+type guardedBody struct {
+	io.ReadCloser
+	rc      *http.ResponseController
+	minRate int64
+	grace   time.Duration
+
+	start time.Time
+	read  int64
+	err   *clientBodyError // First read failure
+}
+
+func (b *guardedBody) Read(p []byte) (int, error) {
+	if b.minRate > 0 {
+		if b.start.IsZero() {
+			b.start = time.Now()
+		}
+		_ = b.rc.SetReadDeadline(time.Now().Add(b.grace))
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+
+	if err == io.EOF {
+		b.setDeadline(time.Time{})
+		return n, err
+	}
+	if err != nil {
+		return n, b.fail(err)
+	}
+	if b.minRate > 0 {
+		elapsed := time.Since(b.start)
+		if elapsed > b.grace && float64(b.read)/elapsed.Seconds() < float64(b.minRate) {
+			// Expire the deadline so the server does not wait for the rest
+			// of the body when it drains it on Close.
+			b.setDeadline(time.Now())
+			return n, b.fail(errSlowClient)
+		}
+	}
+	return n, nil
+}
+
+// Close lets the server drain the unread body under the current deadline,
+// then lifts it so it does not leak into the server's background read.
+func (b *guardedBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.err == nil {
+		b.setDeadline(time.Time{})
+	}
+	return err
+}
+
+func (b *guardedBody) fail(err error) error {
+	if b.err == nil {
+		b.err = &clientBodyError{err: err}
+	}
+	return b.err
+}
+
+func (b *guardedBody) setDeadline(t time.Time) {
+	if b.minRate > 0 {
+		_ = b.rc.SetReadDeadline(t)
+	}
+}
+
+// clientErrorStatus maps a proxy error caused by the client request body to
+// the status to answer with, or 0 when the backend is at fault.
+func clientErrorStatus(r *http.Request, err error) int {
+	var bodyErr *clientBodyError
+	if !errors.As(err, &bodyErr) {
+		guarded, ok := r.Context().Value(clientBodyKey{}).(*guardedBody)
+		if !ok || guarded.err == nil {
+			return 0
+		}
+		bodyErr = guarded.err
+	}
+	// Classify the read error itself: the transport may wrap it in another
+	// net.OpError that does not report the timeout.
+	err = bodyErr.err
+
+	var maxBytes *http.MaxBytesError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &maxBytes):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errSlowClient), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusRequestTimeout
+	}
+	return http.StatusBadRequest
+}
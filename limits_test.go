@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLimitedProxy(t *testing.T, limits LimitsConfig) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		_, _ = w.Write(b)
+	}))
+	t.Cleanup(backend.Close)
+
+	rs := &RouteServer{
+		RouteName: "test",
+		Backend:   backend.URL,
+		config:    &Config{Limits: limits},
+	}
+	routeProxy, err := rs.newRouteProxy()
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.Use(rs.requestLimitsMiddleware())
+	e.Any("/*", routeProxy.handler)
+	srv := httptest.NewServer(e)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRequestLimits_MaxBodySize(t *testing.T) {
+	srv := newLimitedProxy(t, LimitsConfig{MaxBodySize: 8})
+
+	resp, err := http.Post(srv.URL, "text/plain", strings.NewReader("small"))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "small", string(body))
+
+	// Declared length over the limit is rejected before proxying.
+	resp, err = http.Post(srv.URL, "text/plain", strings.NewReader("much too large"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+
+	// Chunked bodies are cut off while streaming.
+	req, err := http.NewRequest(http.MethodPost, srv.URL, io.MultiReader(strings.NewReader("much too "), strings.NewReader("large")))
+	require.NoError(t, err)
+	req.ContentLength = -1
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+}
+
+func TestRequestLimits_SlowClient(t *testing.T) {
+	srv := newLimitedProxy(t, LimitsConfig{MinTransferRate: 1024, MinTransferRateGrace: 100 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Announce a body and then stall.
+	_, err = io.WriteString(conn, "POST / HTTP/1.1\r\nHost: test\r\nContent-Length: 100\r\n\r\nabc")
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusRequestTimeout, resp.StatusCode)
+}
+
+func TestClientErrorStatus(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	assert.Equal(t, 0, clientErrorStatus(req, io.ErrUnexpectedEOF))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, clientErrorStatus(req, &clientBodyError{err: &http.MaxBytesError{Limit: 1}}))
+	assert.Equal(t, http.StatusRequestTimeout, clientErrorStatus(req, &clientBodyError{err: errSlowClient}))
+	assert.Equal(t, http.StatusBadRequest, clientErrorStatus(req, &clientBodyError{err: io.ErrUnexpectedEOF}))
+
+	// A body failure recorded on the request wins over the cancellation it caused.
+	body := &guardedBody{}
+	_ = body.fail(errSlowClient)
+	req = req.WithContext(context.WithValue(req.Context(), clientBodyKey{}, body))
+	assert.Equal(t, http.StatusRequestTimeout, clientErrorStatus(req, context.Canceled))
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v3"
+	"golang.org/x/sync/errgroup"
 	"tailscale.com/client/tailscale/v2"
 )
 
@@ -19,6 +20,16 @@ type server struct {
 	otel     *OpenTelemetry
 	pyro     *Pyroscope
 	tsClient *tailscale.Client
+	keys     authKeyProvider
+
+	nodeStates routeNodeStates // Last known node state of every route
+	routes     routeRegistry   // Running routes, managed through the admin API
+
+	// Group and context routes run in, set by Start
+	routeGroup *errgroup.Group
+	routeCtx   context.Context
+
+	runRouteFn func(ctx context.Context, route *RouteConfig, backendURL string) error // Overrides serveRoute, used by tests
 }
 
 func main() {
@@ -78,12 +89,37 @@ func runServer(ctx context.Context, cmd *cli.Command) error {
 
 	log.Info().Msg("Starting TSGW (Tailscale Gateway)")
 
-	log.Info().Int("routes", len(config.Routes)).Str("domain", config.TailscaleDomain).Int("http-port", config.HTTPPort).Int("https-port", config.HTTPSPort).Msg("Configuration loaded")
+	log.Info().Int("routes", len(config.Routes)).Str("domain", config.TailscaleDomain).Str("control_url", config.ControlURL).Str("auth_key_provider", config.KeyProvider.Provider).Int("http-port", config.HTTPPort).Int("https-port", config.HTTPSPort).Msg("Configuration loaded")
 
-	// Create shared Tailscale client
-	tsClient, err := createTailscaleClient(ctx, config)
+	if err := validateWorkloadIdentity(config); err != nil {
+		return err
+	}
+
+	// Create shared Tailscale client. It is only useful against Tailscale
+	// itself, not against a self-hosted control server.
+	var tsClient *tailscale.Client
+	if config.OAuth.ClientID != "" && config.KeyProvider.Provider != authKeyProviderHeadscale {
+		tsClient, err = createTailscaleClient(ctx, config)
+		if err != nil {
+			return fmt.Errorf("failed to create Tailscale client: %w", err)
+		}
+	}
+
+	keys, err := newAuthKeyProvider(config.KeyProvider, config.AuthKey, tsClient, config.ControlURL)
 	if err != nil {
-		return fmt.Errorf("failed to create Tailscale client: %w", err)
+		return err
+	}
+	if tsClient == nil && config.KeyProvider.Provider == authKeyProviderTailscale && config.AuthKey == (AuthKeyConfig{}) {
+		log.Warn().Msg("No OAuth client or auth key configured; only routes with existing state can start")
+	}
+	if tsClient != nil && config.KeyProvider.Provider == authKeyProviderTailscale {
+		tagSets, err := mintedTagSets(config)
+		if err != nil {
+			return err
+		}
+		if err := checkMintableTags(ctx, tsClient, tagSets); err != nil {
+			return err
+		}
 	}
 
 	server := &server{
@@ -91,6 +127,7 @@ func runServer(ctx context.Context, cmd *cli.Command) error {
 		otel:     otel,
 		pyro:     pyro,
 		tsClient: tsClient,
+		keys:     keys,
 	}
 
 	server.LogRoutes()
@@ -106,7 +143,11 @@ func runServer(ctx context.Context, cmd *cli.Command) error {
 
 func (s *server) LogRoutes() {
 	for routeName, backendURL := range s.config.Routes {
-		fqdn := routeName + "." + s.config.TailscaleDomain
+		hostname := routeName
+		if route, err := s.config.RouteConfig(routeName); err == nil {
+			hostname = route.NodeHostname()
+		}
+		fqdn := hostname + "." + s.config.TailscaleDomain
 		log.Info().Str("route", routeName).Str("backend", backendURL).Str("fqdn", fqdn).Msg("Configured route")
 	}
 }
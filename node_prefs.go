@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/rs/zerolog/log"
+	"tailscale.com/ipn"
+)
+
+// hostnamePattern matches a valid DNS label for a route node
+var hostnamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+func validateNodeHostname(hostname string) error {
+	if !hostnamePattern.MatchString(hostname) {
+		return fmt.Errorf("invalid hostname %q (must be a DNS label of lowercase letters, numbers and dashes)", hostname)
+	}
+	return nil
+}
+
+// NodeHostname is the hostname the route node presents to the control
+// server, which also names its MagicDNS entry and TLS certificate
+func (rc *RouteConfig) NodeHostname() string {
+	if rc.Node.Hostname != "" {
+		return rc.Node.Hostname
+	}
+	return rc.Name
+}
+
+// nodePrefsEditor is the part of the LocalAPI client used to set node prefs
+type nodePrefsEditor interface {
+	EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error)
+}
+
+// routeNodePrefs returns the prefs every route node runs with: it serves
+// HTTP(S) and nothing else. It is neither an exit node nor a subnet router,
+// does not use other nodes as either, and runs no SSH server or web client.
+// Only the accepting of routes and DNS is configurable.
+//
+// Shields-up is left off on purpose: it would also block the serving ports,
+// and a tsnet node already refuses connections on ports tsgw does not listen
+// on. Taildrop is not compiled into tsgw, so incoming files are refused too.
+func routeNodePrefs(route *RouteConfig) *ipn.MaskedPrefs {
+	return &ipn.MaskedPrefs{
+		Prefs: ipn.Prefs{
+			RouteAll:  route.Node.AcceptRoutes,
+			CorpDNS:   route.Node.AcceptDNS,
+			ShieldsUp: false,
+		},
+		RouteAllSet:          true,
+		CorpDNSSet:           true,
+		ShieldsUpSet:         true,
+		ExitNodeIDSet:        true,
+		ExitNodeIPSet:        true,
+		AutoExitNodeSet:      true,
+		AdvertiseRoutesSet:   true,
+		AdvertiseServicesSet: true,
+		RunSSHSet:            true,
+		RunWebClientSet:      true,
+	}
+}
+
+// applyNodePrefs sets the route node prefs and logs the result, so the
+// exposed surface of every node is on record.
+func applyNodePrefs(ctx context.Context, lc nodePrefsEditor, route *RouteConfig) error {
+	prefs, err := lc.EditPrefs(ctx, routeNodePrefs(route))
+	if err != nil {
+		return fmt.Errorf("failed to set node preferences for %s: %w", route.Name, err)
+	}
+	log.Info().
+		Str("route", route.Name).
+		Str("hostname", prefs.Hostname).
+		Bool("accept_routes", prefs.RouteAll).
+		Bool("accept_dns", prefs.CorpDNS).
+		Bool("shields_up", prefs.ShieldsUp).
+		Bool("exit_node", prefs.AdvertisesExitNode()).
+		Int("advertised_routes", len(prefs.AdvertiseRoutes)).
+		Bool("ssh", prefs.RunSSH).
+		Msg("Applied node preferences")
+	return nil
+}
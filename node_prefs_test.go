@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+// fakePrefsEditor applies masked prefs to prefs it holds, like the LocalAPI
+type fakePrefsEditor struct {
+	prefs *ipn.Prefs
+	err   error
+}
+
+func (f *fakePrefsEditor) EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn.Prefs, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.prefs.ApplyEdits(mp)
+	return f.prefs.Clone(), nil
+}
+
+func TestApplyNodePrefs(t *testing.T) {
+	// Start from permissive prefs, as a node might have after login.
+	prefs := ipn.NewPrefs()
+	prefs.Hostname = "app"
+	prefs.RouteAll = true
+	prefs.CorpDNS = true
+	prefs.ShieldsUp = true
+	prefs.RunSSH = true
+	prefs.ExitNodeID = tailcfg.StableNodeID("nEXIT")
+	prefs.AdvertiseRoutes = []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("10.0.0.0/8")}
+	lc := &fakePrefsEditor{prefs: prefs}
+
+	require.NoError(t, applyNodePrefs(context.Background(), lc, &RouteConfig{Name: "app"}))
+	assert.Equal(t, "app", lc.prefs.Hostname, "the hostname is left to tsnet")
+	assert.False(t, lc.prefs.RouteAll)
+	assert.False(t, lc.prefs.CorpDNS)
+	assert.False(t, lc.prefs.ShieldsUp, "shields-up would block the serving ports")
+	assert.False(t, lc.prefs.RunSSH)
+	assert.Empty(t, lc.prefs.ExitNodeID)
+	assert.Empty(t, lc.prefs.AdvertiseRoutes)
+
+	route := &RouteConfig{Name: "app", Node: NodeConfig{AcceptRoutes: true, AcceptDNS: true}}
+	require.NoError(t, applyNodePrefs(context.Background(), lc, route))
+	assert.True(t, lc.prefs.RouteAll)
+	assert.True(t, lc.prefs.CorpDNS)
+
+	lc.err = errors.New("local API unavailable")
+	assert.Error(t, applyNodePrefs(context.Background(), lc, route))
+}
+
+func TestRouteConfig_NodeHostname(t *testing.T) {
+	config := &Config{RouteOptions: map[string]map[string]string{
+		"api": {"hostname": "api-gw"},
+		"bad": {"hostname": "Not_A_Label"},
+	}}
+
+	rc, err := config.RouteConfig("app")
+	require.NoError(t, err)
+	assert.Equal(t, "app", rc.NodeHostname())
+
+	rc, err = config.RouteConfig("api")
+	require.NoError(t, err)
+	assert.Equal(t, "api-gw", rc.NodeHostname())
+
+	_, err = config.RouteConfig("bad")
+	assert.Error(t, err)
+}
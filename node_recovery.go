@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"tailscale.com/ipn"
+)
+
+// errRouteStateInvalid reports that a route node was logged out, expired or
+// deleted, so its state must be reset before logging in again.
+var errRouteStateInvalid = errors.New("route node state is no longer valid")
+
+// hasRouteState reports whether a route node logged in during a previous
+// run. tsnet writes the machine key before any login, so only a persisted
+// login profile counts. A nil store is tsnet's default file store in dir.
+func hasRouteState(store ipn.StateStore, dir string) bool {
+	if store == nil {
+		data, err := os.ReadFile(filepath.Join(dir, "tailscaled.state"))
+		if err != nil {
+			return false
+		}
+		var state map[ipn.StateKey][]byte
+		if err := json.Unmarshal(data, &state); err != nil {
+			return false
+		}
+		return len(state[ipn.CurrentProfileStateKey]) > 0
+	}
+	profile, err := store.ReadState(ipn.CurrentProfileStateKey)
+	return err == nil && len(profile) > 0
+}
+
+// shouldResetState reports whether a node asking for a login must have its
+// state reset: only state that was logged in before is stale, a node that
+// never finished its first login just logs in.
+func shouldResetState(backendState string, hadState, recoverState bool) bool {
+	return backendState == ipn.NeedsLogin.String() && hadState && recoverState
+}
+
+// resetRouteState moves a route's state directory aside, replacing the
+// previous backup, so the next start registers a fresh node. The state of
+// other routes is left alone.
+func resetRouteState(route *RouteConfig, dir string) error {
+	switch route.State.Store {
+	case stateStoreMem:
+		return nil
+	case stateStoreKube:
+		return errors.New("the kube state store cannot be reset automatically")
+	}
+
+	backup := dir + ".bak"
+	if err := os.RemoveAll(backup); err != nil {
+		return fmt.Errorf("failed to remove previous state backup %s: %w", backup, err)
+	}
+	if err := os.Rename(dir, backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to back up state directory %s: %w", dir, err)
+	}
+	log.Warn().Str("route", route.Name).Str("backup", backup).Msg("Reset route state; previous state backed up")
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+)
+
+// writeRouteState writes a tsnet file store holding a machine key and, when
+// loggedIn, the login profile written after a successful login
+func writeRouteState(t *testing.T, dir string, loggedIn bool) {
+	t.Helper()
+	state := map[ipn.StateKey][]byte{ipn.MachineKeyStateKey: []byte("privkey:00")}
+	if loggedIn {
+		state[ipn.CurrentProfileStateKey] = []byte("profile-1")
+	}
+	data, err := json.Marshal(state)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(dir, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tailscaled.state"), data, 0o600))
+}
+
+func TestHasRouteState(t *testing.T) {
+	root := t.TempDir()
+	assert.False(t, hasRouteState(nil, filepath.Join(root, "missing")))
+
+	// A node whose first login failed only has its machine key.
+	fresh := filepath.Join(root, "fresh")
+	writeRouteState(t, fresh, false)
+	assert.False(t, hasRouteState(nil, fresh))
+
+	loggedIn := filepath.Join(root, "app")
+	writeRouteState(t, loggedIn, true)
+	assert.True(t, hasRouteState(nil, loggedIn))
+
+	corrupt := filepath.Join(root, "corrupt")
+	require.NoError(t, os.MkdirAll(corrupt, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(corrupt, "tailscaled.state"), []byte("{"), 0o600))
+	assert.False(t, hasRouteState(nil, corrupt))
+
+	store := new(mem.Store)
+	require.NoError(t, store.WriteState(ipn.MachineKeyStateKey, []byte("privkey:00")))
+	assert.False(t, hasRouteState(store, root))
+	require.NoError(t, store.WriteState(ipn.CurrentProfileStateKey, []byte("profile-1")))
+	assert.True(t, hasRouteState(store, root))
+}
+
+func TestShouldResetState(t *testing.T) {
+	root := t.TempDir()
+	fresh := filepath.Join(root, "fresh")
+	writeRouteState(t, fresh, false)
+	loggedIn := filepath.Join(root, "app")
+	writeRouteState(t, loggedIn, true)
+
+	needsLogin := ipn.NeedsLogin.String()
+	// A previously logged-in node asking for a login was logged out,
+	// expired or deleted.
+	assert.True(t, shouldResetState(needsLogin, hasRouteState(nil, loggedIn), true))
+	// A node that never finished its first login logs in fresh and keeps
+	// the backup of an earlier reset.
+	assert.False(t, shouldResetState(needsLogin, hasRouteState(nil, fresh), true))
+	// After a reset the node always logs in fresh.
+	assert.False(t, shouldResetState(needsLogin, hasRouteState(nil, loggedIn), false))
+	// Only NeedsLogin resets; other states are handled by the node watcher.
+	assert.False(t, shouldResetState(ipn.NeedsMachineAuth.String(), true, true))
+	assert.False(t, shouldResetState(ipn.Running.String(), true, true))
+}
+
+func TestResetRouteState(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "app")
+	other := filepath.Join(root, "web")
+	writeRouteState(t, dir, true)
+	writeRouteState(t, other, true)
+	require.NoError(t, os.MkdirAll(dir+".bak", 0o700))
+
+	route := &RouteConfig{Name: "app"}
+	assert.True(t, hasRouteState(nil, dir))
+	require.NoError(t, resetRouteState(route, dir))
+
+	assert.False(t, hasRouteState(nil, dir))
+	assert.FileExists(t, filepath.Join(dir+".bak", "tailscaled.state"))
+	assert.True(t, hasRouteState(nil, other))
+
+	// Resetting again without state is not an error.
+	require.NoError(t, resetRouteState(route, dir))
+
+	assert.Error(t, resetRouteState(&RouteConfig{Name: "app", State: StateConfig{Store: stateStoreKube}}, dir))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+	"tailscale.com/client/local"
+	"tailscale.com/ipn"
+)
+
+// ipnBusRetryDelay is the pause before re-subscribing to a failed IPN bus
+const ipnBusRetryDelay = time.Second
+
+// nodeStatus is the last known backend state of a route node
+type nodeStatus struct {
+	State ipn.State
+	Since time.Time
+}
+
+// Healthy reports whether the node can serve traffic
+func (st nodeStatus) Healthy() bool { return st.State == ipn.Running }
+
+// routeNodeStates tracks the node state of every route. The zero value is
+// ready to use.
+type routeNodeStates struct {
+	mu     sync.Mutex
+	states map[string]nodeStatus
+}
+
+func (r *routeNodeStates) set(routeName string, state ipn.State) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.states == nil {
+		r.states = make(map[string]nodeStatus)
+	}
+	if st, ok := r.states[routeName]; ok && st.State == state {
+		return
+	}
+	r.states[routeName] = nodeStatus{State: state, Since: time.Now()}
+}
+
+func (r *routeNodeStates) remove(routeName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.states, routeName)
+}
+
+func (r *routeNodeStates) get(routeName string) (nodeStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st, ok := r.states[routeName]
+	return st, ok
+}
+
+// nodeStateWatcher follows a route node through the IPN bus, logging and
+// recording every backend state transition.
+type nodeStateWatcher struct {
+	routeName string
+	lc        *local.Client
+	states    *routeNodeStates
+
+	changes metric.Int64Counter
+	running metric.Int64Gauge
+}
+
+func newNodeStateWatcher(routeName string, lc *local.Client, states *routeNodeStates, meter metric.Meter) *nodeStateWatcher {
+	w := &nodeStateWatcher{routeName: routeName, lc: lc, states: states}
+
+	var err error
+	w.changes, err = meter.Int64Counter("tsgw.node.state_changes",
+		metric.WithDescription("Route node backend state transitions by new state"),
+		metric.WithUnit("{transition}"))
+	if err != nil {
+		log.Warn().Err(err).Str("route", routeName).Msg("Failed to create node state metric")
+	}
+	w.running, err = meter.Int64Gauge("tsgw.node.running",
+		metric.WithDescription("Whether the route node is running (1) or not (0)"))
+	if err != nil {
+		log.Warn().Err(err).Str("route", routeName).Msg("Failed to create node running metric")
+	}
+	return w
+}
+
+// run watches the IPN bus until ctx is done, returning nil, or until the
+// node needs a new login, returning errRouteStateInvalid.
+func (w *nodeStateWatcher) run(ctx context.Context) error {
+	var last ipn.State
+	for {
+		err := w.watch(ctx, &last)
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(ipnBusRetryDelay):
+		}
+	}
+}
+
+// watch follows one IPN bus subscription. It returns nil when the
+// subscription ends and should be retried.
+func (w *nodeStateWatcher) watch(ctx context.Context, last *ipn.State) error {
+	watcher, err := w.lc.WatchIPNBus(ctx, ipn.NotifyInitialState|ipn.NotifyNoPrivateKeys)
+	if err != nil {
+		log.Debug().Err(err).Str("route", w.routeName).Msg("Failed to watch IPN bus")
+		return nil
+	}
+	defer watcher.Close()
+
+	for {
+		n, err := watcher.Next()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Debug().Err(err).Str("route", w.routeName).Msg("IPN bus watch ended")
+			}
+			return nil
+		}
+		if n.ErrMessage != nil {
+			log.Warn().Str("route", w.routeName).Str("error", *n.ErrMessage).Msg("Tailscale backend error")
+		}
+		if n.State == nil || *n.State == *last {
+			continue
+		}
+		w.transition(ctx, *last, *n.State)
+		*last = *n.State
+
+		switch *n.State {
+		case ipn.NeedsLogin:
+			log.Warn().Str("route", w.routeName).Str("state", n.State.String()).Msg("Route node was logged out, expired or deleted")
+			return errRouteStateInvalid
+		case ipn.NeedsMachineAuth:
+			// Logging in again would only add another unapproved device.
+			log.Warn().Str("route", w.routeName).Msg("Route node is waiting for approval by a tailnet admin; route is unhealthy")
+		}
+	}
+}
+
+func (w *nodeStateWatcher) transition(ctx context.Context, from, to ipn.State) {
+	w.states.set(w.routeName, to)
+
+	logger := log.With().Str("route", w.routeName).Str("from", from.String()).Str("state", to.String()).Logger()
+	switch {
+	case to == ipn.Running:
+		logger.Info().Msg("Route node is running")
+	case from == ipn.Running:
+		logger.Warn().Msg("Route node stopped running; route is unhealthy")
+	default:
+		logger.Info().Msg("Route node state changed")
+	}
+
+	attrs := metric.WithAttributes(attribute.String("route.name", w.routeName))
+	if w.changes != nil {
+		w.changes.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("route.name", w.routeName),
+			attribute.String("state", to.String()),
+		))
+	}
+	if w.running != nil {
+		running := int64(0)
+		if to == ipn.Running {
+			running = 1
+		}
+		w.running.Record(ctx, running, attrs)
+	}
+	trace.SpanFromContext(ctx).AddEvent("node.state", trace.WithAttributes(
+		attribute.String("from", from.String()),
+		attribute.String("state", to.String()),
+	))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/metric/noop"
+	"tailscale.com/client/local"
+	"tailscale.com/ipn"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// newFakeIPNBus returns a LocalAPI client whose IPN bus streams the
+// notifications sent on the returned channel.
+func newFakeIPNBus(t *testing.T) (*local.Client, chan<- ipn.Notify) {
+	t.Helper()
+	notify := make(chan ipn.Notify)
+	lc := &local.Client{
+		OmitAuth: true,
+		Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			pr, pw := io.Pipe()
+			go func() {
+				enc := json.NewEncoder(pw)
+				for {
+					select {
+					case n := <-notify:
+						if err := enc.Encode(n); err != nil {
+							return
+						}
+					case <-r.Context().Done():
+						pw.CloseWithError(r.Context().Err())
+						return
+					}
+				}
+			}()
+			return &http.Response{StatusCode: http.StatusOK, Body: pr, Header: make(http.Header), Request: r}, nil
+		}),
+	}
+	return lc, notify
+}
+
+func stateNotify(s ipn.State) ipn.Notify { return ipn.Notify{State: &s} }
+
+func TestNodeStateWatcher(t *testing.T) {
+	lc, notify := newFakeIPNBus(t)
+	var states routeNodeStates
+	w := newNodeStateWatcher("app", lc, &states, noop.NewMeterProvider().Meter("test"))
+
+	done := make(chan error, 1)
+	go func() { done <- w.run(context.Background()) }()
+
+	notify <- stateNotify(ipn.Running)
+	notify <- stateNotify(ipn.Starting)
+	require.Eventually(t, func() bool {
+		st, ok := states.get("app")
+		return ok && st.State == ipn.Starting
+	}, time.Second, 5*time.Millisecond)
+	st, _ := states.get("app")
+	assert.False(t, st.Healthy())
+
+	// A device awaiting approval stays unhealthy without a new login.
+	notify <- stateNotify(ipn.NeedsMachineAuth)
+	require.Eventually(t, func() bool {
+		st, ok := states.get("app")
+		return ok && st.State == ipn.NeedsMachineAuth
+	}, time.Second, 5*time.Millisecond)
+	st, _ = states.get("app")
+	assert.False(t, st.Healthy())
+	select {
+	case err := <-done:
+		t.Fatalf("watcher stopped while the device awaits approval: %v", err)
+	default:
+	}
+
+	notify <- stateNotify(ipn.Running)
+	notify <- stateNotify(ipn.NeedsLogin)
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, errRouteStateInvalid)
+	case <-time.After(2 * time.Second):
+		t.Fatal("watcher did not detect the logout")
+	}
+	st, _ = states.get("app")
+	assert.Equal(t, ipn.NeedsLogin, st.State)
+}
+
+func TestNodeStateWatcher_Cancel(t *testing.T) {
+	lc, notify := newFakeIPNBus(t)
+	var states routeNodeStates
+	w := newNodeStateWatcher("app", lc, &states, noop.NewMeterProvider().Meter("test"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.run(ctx) }()
+	notify <- stateNotify(ipn.Running)
+	require.Eventually(t, func() bool {
+		st, ok := states.get("app")
+		return ok && st.Healthy()
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("watcher did not stop")
+	}
+}
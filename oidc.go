@@ -0,0 +1,413 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+)
+
+// OIDC endpoints served by the gateway itself on every protected route
+const (
+	oidcCallbackPath = "/.tsgw/oidc/callback"
+	oidcLogoutPath   = "/.tsgw/oidc/logout"
+)
+
+// Headers forwarded to the backend with the authenticated identity, named
+// after the oauth2-proxy ones so existing backends work unchanged. Client
+// supplied values are always removed first.
+const (
+	headerAuthUser   = "X-Auth-Request-User"
+	headerAuthEmail  = "X-Auth-Request-Email"
+	headerAuthGroups = "X-Auth-Request-Groups"
+)
+
+const (
+	oidcSessionCookie = "_tsgw_oidc"
+	oidcStateCookie   = "_tsgw_oidc_state"
+	oidcStateTTL      = 10 * time.Minute
+)
+
+// validateRequiredClaims checks the claim=value format of --oidc-required-claims
+func validateRequiredClaims(claims []string) error {
+	for _, c := range claims {
+		if name, value, ok := strings.Cut(c, "="); !ok || name == "" || value == "" {
+			return fmt.Errorf("invalid required claim %q (expected claim=value)", c)
+		}
+	}
+	return nil
+}
+
+// validateSessionTTL rejects session lifetimes that would expire a session
+// as soon as it is issued and send every request back to the provider
+func validateSessionTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("OIDC session TTL must be positive, got %s", ttl)
+	}
+	return nil
+}
+
+// oidcSession is stored encrypted in the session cookie
+type oidcSession struct {
+	Subject string    `json:"sub"`
+	Email   string    `json:"email,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Groups  []string  `json:"groups,omitempty"`
+	Expires time.Time `json:"exp"`
+}
+
+// oidcLoginState carries the data of a pending login through the provider
+type oidcLoginState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	Verifier string    `json:"verifier"`
+	Redirect string    `json:"rd"`
+	Expires  time.Time `json:"exp"`
+}
+
+// oidcAuth is an OpenID Connect relying party protecting a route
+type oidcAuth struct {
+	routeName string
+	cfg       OIDCConfig
+	aead      cipher.AEAD
+	client    *http.Client // Used for discovery, JWKS and token requests
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+	endSess  string // Provider logout endpoint, when advertised
+}
+
+func newOIDCAuth(routeName string, cfg OIDCConfig) (*oidcAuth, error) {
+	if cfg.ClientID == "" {
+		return nil, errors.New("OIDC requires a client ID")
+	}
+	if err := validateRequiredClaims(cfg.RequiredClaims); err != nil {
+		return nil, err
+	}
+
+	secret := []byte(cfg.CookieSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		log.Warn().Str("route", routeName).Msg("No OIDC cookie secret configured; sessions will not survive a restart")
+	}
+	// The secret is shared by every route; each route gets its own key.
+	key, err := hkdf.Key(sha256.New, secret, nil, "tsgw oidc cookie "+routeName, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oidcAuth{
+		routeName: routeName,
+		cfg:       cfg,
+		aead:      aead,
+		client:    &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// getProvider discovers the provider on first use, so an unreachable
+// issuer does not keep the route from starting.
+func (a *oidcAuth) getProvider(ctx context.Context) (*oidc.Provider, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.provider != nil {
+		return a.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, a.client), a.cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	var extra struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	_ = provider.Claims(&extra)
+
+	a.provider = provider
+	a.endSess = extra.EndSessionEndpoint
+	return provider, nil
+}
+
+func (a *oidcAuth) oauth2Config(provider *oidc.Provider, r *http.Request) *oauth2.Config {
+	redirectURL := a.cfg.RedirectURL
+	if redirectURL == "" {
+		redirectURL = "https://" + r.Host + oidcCallbackPath
+	}
+	return &oauth2.Config{
+		ClientID:     a.cfg.ClientID,
+		ClientSecret: a.cfg.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       a.cfg.Scopes,
+	}
+}
+
+func (a *oidcAuth) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		req.Header.Del(headerAuthUser)
+		req.Header.Del(headerAuthEmail)
+		req.Header.Del(headerAuthGroups)
+
+		switch req.URL.Path {
+		case oidcCallbackPath:
+			return a.callback(c)
+		case oidcLogoutPath:
+			return a.logout(c)
+		}
+
+		var sess oidcSession
+		if a.readCookie(req, oidcSessionCookie, &sess) && time.Now().Before(sess.Expires) {
+			req.Header.Set(headerAuthUser, sess.Subject)
+			if sess.Email != "" {
+				req.Header.Set(headerAuthEmail, sess.Email)
+			}
+			if len(sess.Groups) > 0 {
+				req.Header.Set(headerAuthGroups, strings.Join(sess.Groups, ","))
+			}
+			// The cookie is for the gateway only.
+			stripCookie(req, oidcSessionCookie)
+			return next(c)
+		}
+
+		// Only navigations can follow a login redirect; API calls get 401.
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			return echo.ErrUnauthorized
+		}
+		return a.login(c)
+	}
+}
+
+func (a *oidcAuth) login(c echo.Context) error {
+	req := c.Request()
+	provider, err := a.getProvider(req.Context())
+	if err != nil {
+		log.Error().Err(err).Str("route", a.routeName).Msg("OIDC login unavailable")
+		return echo.ErrServiceUnavailable
+	}
+
+	st := oidcLoginState{
+		State:    rand.Text(),
+		Nonce:    rand.Text(),
+		Verifier: oauth2.GenerateVerifier(),
+		Redirect: req.URL.RequestURI(),
+		Expires:  time.Now().Add(oidcStateTTL),
+	}
+	if err := a.writeCookie(c, oidcStateCookie, st, oidcStateTTL); err != nil {
+		return err
+	}
+
+	authURL := a.oauth2Config(provider, req).AuthCodeURL(st.State, oidc.Nonce(st.Nonce), oauth2.S256ChallengeOption(st.Verifier))
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+func (a *oidcAuth) callback(c echo.Context) error {
+	req := c.Request()
+	var st oidcLoginState
+	if !a.readCookie(req, oidcStateCookie, &st) || time.Now().After(st.Expires) || req.URL.Query().Get("state") != st.State {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid or expired login state")
+	}
+	a.clearCookie(c, oidcStateCookie)
+
+	if e := req.URL.Query().Get("error"); e != "" {
+		log.Warn().Str("route", a.routeName).Str("error", e).Str("description", req.URL.Query().Get("error_description")).Msg("OIDC provider rejected login")
+		return echo.NewHTTPError(http.StatusForbidden, "login failed")
+	}
+
+	provider, err := a.getProvider(req.Context())
+	if err != nil {
+		log.Error().Err(err).Str("route", a.routeName).Msg("OIDC login unavailable")
+		return echo.ErrServiceUnavailable
+	}
+	ctx := oidc.ClientContext(req.Context(), a.client)
+	token, err := a.oauth2Config(provider, req).Exchange(ctx, req.URL.Query().Get("code"), oauth2.VerifierOption(st.Verifier))
+	if err != nil {
+		log.Warn().Err(err).Str("route", a.routeName).Msg("OIDC code exchange failed")
+		return echo.NewHTTPError(http.StatusBadGateway, "login failed")
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadGateway, "provider returned no ID token")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: a.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != st.Nonce {
+		log.Warn().Err(err).Str("route", a.routeName).Msg("Invalid OIDC ID token")
+		return echo.NewHTTPError(http.StatusForbidden, "login failed")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return err
+	}
+	sess := oidcSession{
+		Subject: idToken.Subject,
+		Email:   claimString(claims, "email"),
+		Name:    claimString(claims, "name"),
+		Groups:  claimStrings(claims, a.cfg.GroupsClaim),
+		Expires: time.Now().Add(a.cfg.SessionTTL),
+	}
+	if err := a.authorize(claims, sess.Groups); err != nil {
+		log.Info().Err(err).Str("route", a.routeName).Str("subject", sess.Subject).Str("email", sess.Email).Msg("OIDC login denied")
+		return echo.NewHTTPError(http.StatusForbidden, "access denied")
+	}
+
+	if err := a.writeCookie(c, oidcSessionCookie, sess, a.cfg.SessionTTL); err != nil {
+		return err
+	}
+	log.Debug().Str("route", a.routeName).Str("subject", sess.Subject).Str("email", sess.Email).Msg("OIDC login succeeded")
+
+	redirect := st.Redirect
+	// Only same-origin paths, never "//host" or absolute URLs.
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = "/"
+	}
+	return c.Redirect(http.StatusFound, redirect)
+}
+
+// authorize applies the group and claim checks to a login
+func (a *oidcAuth) authorize(claims map[string]any, groups []string) error {
+	if len(a.cfg.AllowedGroups) > 0 && !slices.ContainsFunc(groups, func(g string) bool {
+		return slices.Contains(a.cfg.AllowedGroups, g)
+	}) {
+		return errors.New("not a member of an allowed group")
+	}
+	for _, rc := range a.cfg.RequiredClaims {
+		name, value, _ := strings.Cut(rc, "=")
+		if !slices.Contains(claimStrings(claims, name), value) {
+			return fmt.Errorf("claim %s does not match", name)
+		}
+	}
+	return nil
+}
+
+func (a *oidcAuth) logout(c echo.Context) error {
+	a.clearCookie(c, oidcSessionCookie)
+
+	a.mu.Lock()
+	endSess := a.endSess
+	a.mu.Unlock()
+	if endSess == "" {
+		return c.Redirect(http.StatusFound, "/")
+	}
+	u, err := url.Parse(endSess)
+	if err != nil {
+		return c.Redirect(http.StatusFound, "/")
+	}
+	q := u.Query()
+	q.Set("client_id", a.cfg.ClientID)
+	q.Set("post_logout_redirect_uri", "https://"+c.Request().Host+"/")
+	u.RawQuery = q.Encode()
+	return c.Redirect(http.StatusFound, u.String())
+}
+
+// writeCookie stores v encrypted in a cookie readable only by the gateway
+func (a *oidcAuth) writeCookie(c echo.Context, name string, v any, ttl time.Duration) error {
+	plain, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, a.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	// The cookie and route names are authenticated so a state cookie cannot
+	// pose as a session, nor a session of one route be used on another.
+	sealed := a.aead.Seal(nonce, nonce, plain, a.cookieAD(name))
+	c.SetCookie(&http.Cookie{
+		Name:     name,
+		Value:    base64.RawURLEncoding.EncodeToString(sealed),
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+func (a *oidcAuth) readCookie(r *http.Request, name string, v any) bool {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return false
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(sealed) < a.aead.NonceSize() {
+		return false
+	}
+	nonce, ciphertext := sealed[:a.aead.NonceSize()], sealed[a.aead.NonceSize():]
+	plain, err := a.aead.Open(nil, nonce, ciphertext, a.cookieAD(name))
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(plain, v) == nil
+}
+
+// cookieAD is the additional data authenticated with a cookie
+func (a *oidcAuth) cookieAD(name string) []byte {
+	return []byte(a.routeName + "\x00" + name)
+}
+
+func (a *oidcAuth) clearCookie(c echo.Context, name string) {
+	c.SetCookie(&http.Cookie{Name: name, Value: "", Path: "/", MaxAge: -1, Secure: true, HttpOnly: true})
+}
+
+// stripCookie removes one cookie from the request before it is proxied
+func stripCookie(r *http.Request, name string) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, ck := range cookies {
+		if ck.Name != name {
+			r.AddCookie(ck)
+		}
+	}
+}
+
+func claimString(claims map[string]any, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// claimStrings reads a claim that may be a single string or a list of them
+func claimStrings(claims map[string]any, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
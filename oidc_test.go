@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOIDCProvider is a minimal OpenID provider issuing ID tokens with the
+// configured claims for any authorization code.
+type fakeOIDCProvider struct {
+	*httptest.Server
+	key    *ecdsa.PrivateKey
+	mu     sync.Mutex
+	nonce  string
+	claims map[string]any
+}
+
+func newFakeOIDCProvider(t *testing.T, claims map[string]any) *fakeOIDCProvider {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	p := &fakeOIDCProvider{key: key, claims: claims}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"end_session_endpoint":                  p.URL + "/logout",
+			"id_token_signing_alg_values_supported": []string{"ES256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: "k1", Algorithm: "ES256", Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		claims := map[string]any{
+			"iss":   p.URL,
+			"aud":   "tsgw",
+			"sub":   "user-1",
+			"nonce": p.nonce,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range p.claims {
+			claims[k] = v
+		}
+		p.mu.Unlock()
+
+		signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: p.key}, (&jose.SignerOptions{}).WithHeader("kid", "k1"))
+		require.NoError(t, err)
+		payload, _ := json.Marshal(claims)
+		sig, err := signer.Sign(payload)
+		require.NoError(t, err)
+		idToken, _ := sig.CompactSerialize()
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"id_token":     idToken,
+		})
+	})
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func newOIDCEcho(t *testing.T, cfg OIDCConfig) *echo.Echo {
+	t.Helper()
+	return newOIDCRouteEcho(t, "test", cfg)
+}
+
+func newOIDCRouteEcho(t *testing.T, routeName string, cfg OIDCConfig) *echo.Echo {
+	t.Helper()
+	auth, err := newOIDCAuth(routeName, cfg)
+	require.NoError(t, err)
+	e := echo.New()
+	e.Use(auth.middleware)
+	e.Any("/*", func(c echo.Context) error {
+		h := c.Request().Header
+		return c.String(http.StatusOK, h.Get(headerAuthUser)+"|"+h.Get(headerAuthEmail)+"|"+h.Get(headerAuthGroups)+"|"+h.Get("Cookie"))
+	})
+	return e
+}
+
+// oidcLogin runs the login flow for path and returns the session cookie
+func oidcLogin(t *testing.T, e *echo.Echo, p *fakeOIDCProvider, path string) (*httptest.ResponseRecorder, *http.Cookie) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusFound, rec.Code)
+
+	authURL, err := url.Parse(rec.Header().Get("Location"))
+	require.NoError(t, err)
+	assert.Equal(t, "/authorize", authURL.Path)
+	assert.Equal(t, "S256", authURL.Query().Get("code_challenge_method"))
+	p.mu.Lock()
+	p.nonce = authURL.Query().Get("nonce")
+	p.mu.Unlock()
+
+	cb := httptest.NewRequest(http.MethodGet, oidcCallbackPath+"?code=abc&state="+url.QueryEscape(authURL.Query().Get("state")), nil)
+	for _, ck := range rec.Result().Cookies() {
+		cb.AddCookie(ck)
+	}
+	cbRec := httptest.NewRecorder()
+	e.ServeHTTP(cbRec, cb)
+
+	for _, ck := range cbRec.Result().Cookies() {
+		if ck.Name == oidcSessionCookie && ck.MaxAge > 0 {
+			return cbRec, ck
+		}
+	}
+	return cbRec, nil
+}
+
+func testOIDCConfig(issuer string) OIDCConfig {
+	return OIDCConfig{
+		Issuer:       issuer,
+		ClientID:     "tsgw",
+		ClientSecret: "secret",
+		Scopes:       []string{"openid", "email"},
+		CookieSecret: "test-secret",
+		SessionTTL:   time.Hour,
+		GroupsClaim:  "groups",
+	}
+}
+
+func TestOIDC_LoginFlow(t *testing.T) {
+	p := newFakeOIDCProvider(t, map[string]any{"email": "alice@example.com", "groups": []string{"admins", "dev"}})
+	cfg := testOIDCConfig(p.URL)
+	cfg.AllowedGroups = []string{"admins"}
+	e := newOIDCEcho(t, cfg)
+
+	cbRec, session := oidcLogin(t, e, p, "/dashboard?tab=1")
+	require.NotNil(t, session)
+	assert.Equal(t, "/dashboard?tab=1", cbRec.Header().Get("Location"))
+
+	req := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+	req.AddCookie(session)
+	req.AddCookie(&http.Cookie{Name: "app", Value: "1"})
+	req.Header.Set(headerAuthUser, "spoofed")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "user-1|alice@example.com|admins,dev|app=1", rec.Body.String())
+}
+
+func TestOIDC_Denied(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+		modify func(*OIDCConfig)
+	}{
+		{
+			name:   "group not allowed",
+			claims: map[string]any{"groups": []string{"dev"}},
+			modify: func(c *OIDCConfig) { c.AllowedGroups = []string{"admins"} },
+		},
+		{
+			name:   "required claim missing",
+			claims: map[string]any{"hd": "other.com"},
+			modify: func(c *OIDCConfig) { c.RequiredClaims = []string{"hd=example.com"} },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newFakeOIDCProvider(t, tt.claims)
+			cfg := testOIDCConfig(p.URL)
+			tt.modify(&cfg)
+			e := newOIDCEcho(t, cfg)
+
+			cbRec, session := oidcLogin(t, e, p, "/")
+			assert.Equal(t, http.StatusForbidden, cbRec.Code)
+			assert.Nil(t, session)
+		})
+	}
+}
+
+func TestOIDC_SessionBoundToRoute(t *testing.T) {
+	p := newFakeOIDCProvider(t, map[string]any{"groups": []string{"dev"}})
+	open := newOIDCRouteEcho(t, "open", testOIDCConfig(p.URL))
+	_, session := oidcLogin(t, open, p, "/")
+	require.NotNil(t, session)
+
+	// Same cookie secret, but a route restricted to another group.
+	cfg := testOIDCConfig(p.URL)
+	cfg.AllowedGroups = []string{"admins"}
+	restricted := newOIDCRouteEcho(t, "restricted", cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(session)
+	rec := httptest.NewRecorder()
+	restricted.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Location"), p.URL+"/authorize"))
+}
+
+func TestOIDC_Unauthenticated(t *testing.T) {
+	p := newFakeOIDCProvider(t, nil)
+	e := newOIDCEcho(t, testOIDCConfig(p.URL))
+
+	// API calls are not redirected.
+	req := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader("{}"))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// A forged session cookie is ignored.
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: oidcSessionCookie, Value: "Zm9yZ2Vk"})
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusFound, rec.Code)
+
+	// A callback without a matching state cookie is rejected.
+	req = httptest.NewRequest(http.MethodGet, oidcCallbackPath+"?code=abc&state=x", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestOIDC_Logout(t *testing.T) {
+	p := newFakeOIDCProvider(t, nil)
+	e := newOIDCEcho(t, testOIDCConfig(p.URL))
+	_, session := oidcLogin(t, e, p, "/")
+	require.NotNil(t, session)
+
+	req := httptest.NewRequest(http.MethodGet, oidcLogoutPath, nil)
+	req.AddCookie(session)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Header().Get("Location"), p.URL+"/logout"))
+	cleared := false
+	for _, ck := range rec.Result().Cookies() {
+		cleared = cleared || (ck.Name == oidcSessionCookie && ck.MaxAge < 0)
+	}
+	assert.True(t, cleared)
+}
+
+func TestOIDC_SessionTTL(t *testing.T) {
+	assert.NoError(t, validateSessionTTL(time.Hour))
+	assert.Error(t, validateSessionTTL(0))
+	assert.Error(t, validateSessionTTL(-time.Minute))
+
+	rc := &RouteConfig{OIDC: OIDCConfig{SessionTTL: time.Hour}}
+	assert.Error(t, applyRouteOption(rc, "oidc-session-ttl", "0s"))
+	assert.Error(t, applyRouteOption(rc, "oidc-session-ttl", "-1h"))
+	assert.Equal(t, time.Hour, rc.OIDC.SessionTTL)
+	require.NoError(t, applyRouteOption(rc, "oidc-session-ttl", "30m"))
+	assert.Equal(t, 30*time.Minute, rc.OIDC.SessionTTL)
+}
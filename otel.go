@@ -146,6 +146,15 @@ func createMetricExporter(ctx context.Context, config *Config) (sdkmetric.Export
 	return otlpmetricgrpc.New(ctx, opts...)
 }
 
+// meter returns the configured meter, falling back to a no-op one when
+// OpenTelemetry was not set up (e.g. in tests)
+func (ot *OpenTelemetry) meter() metric.Meter {
+	if ot == nil || ot.Meter == nil {
+		return noop.NewMeterProvider().Meter("tsgw")
+	}
+	return ot.Meter
+}
+
 // Shutdown gracefully shuts down OpenTelemetry components
 func (ot *OpenTelemetry) Shutdown(ctx context.Context) error {
 	log.Info().Msg("Shutting down OpenTelemetry")
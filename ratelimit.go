@@ -0,0 +1,60 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitMiddleware enforces a token bucket per client identity. Requests
+// over the limit get a 429 with a Retry-After hint.
+func (rs *RouteServer) rateLimitMiddleware() echo.MiddlewareFunc {
+	cfg := rs.route.RateLimit
+
+	burst := cfg.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(cfg.Rate))
+	}
+
+	// Time until a single token is available again, rounded up to whole seconds.
+	retryAfter := strconv.Itoa(int(math.Max(1, math.Ceil(1/cfg.Rate))))
+
+	throttled, err := rs.otel.meter().Int64Counter("tsgw.ratelimit.throttled",
+		metric.WithDescription("Requests rejected by the route rate limiter"),
+		metric.WithUnit("{request}"))
+	if err != nil {
+		log.Warn().Err(err).Str("route", rs.RouteName).Msg("Failed to create rate limit metric")
+	}
+	attrs := metric.WithAttributes(
+		attribute.String("route.name", rs.RouteName),
+		attribute.String("ratelimit.key", cfg.Key),
+	)
+
+	store := middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+		Rate:      rate.Limit(cfg.Rate),
+		Burst:     burst,
+		ExpiresIn: 3 * time.Minute,
+	})
+
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Store: store,
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			return rs.identify(c).key(cfg.Key), nil
+		},
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			if throttled != nil {
+				throttled.Add(c.Request().Context(), 1, attrs)
+			}
+			log.Debug().Str("route", rs.RouteName).Str("client", identifier).Str("path", c.Request().URL.Path).Msg("Request rate limited")
+			c.Response().Header().Set("Retry-After", retryAfter)
+			return echo.ErrTooManyRequests
+		},
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+func TestPeerIdentity_Key(t *testing.T) {
+	tagged := &peerIdentity{IP: "100.64.0.1", NodeID: "n1", Tags: []string{"tag:ci"}}
+	user := &peerIdentity{IP: "100.64.0.2", NodeID: "n2", Login: "alice@example.com"}
+	funnel := &peerIdentity{IP: "203.0.113.7"}
+
+	assert.Equal(t, "node:n1", tagged.key("node"))
+	assert.Equal(t, "tag:ci", tagged.key("tag"))
+	assert.Equal(t, "ip:100.64.0.1", tagged.key("user"))
+	assert.Equal(t, "user:alice@example.com", user.key("user"))
+	assert.Equal(t, "ip:203.0.113.7", funnel.key("node"))
+	assert.Equal(t, "ip:100.64.0.2", user.key("ip"))
+}
+
+func TestRouteServer_RateLimitMiddleware(t *testing.T) {
+	rs := &RouteServer{
+		RouteName: "test",
+		route: &RouteConfig{
+			Name:      "test",
+			RateLimit: RateLimitConfig{Rate: 0.5, Burst: 1, Key: "user"},
+		},
+		whoIsFn: func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error) {
+			if remoteAddr == "100.64.0.1:1234" {
+				return &apitype.WhoIsResponse{
+					Node:        &tailcfg.Node{StableID: "n1", Name: "laptop.example.ts.net."},
+					UserProfile: &tailcfg.UserProfile{LoginName: "alice@example.com"},
+				}, nil
+			}
+			return nil, errors.New("not found")
+		},
+	}
+
+	e := echo.New()
+	e.Use(rs.rateLimitMiddleware())
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	do := func(remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, do("100.64.0.1:1234").Code)
+
+	rec := do("100.64.0.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+
+	// Other clients have their own bucket.
+	assert.Equal(t, http.StatusOK, do("203.0.113.7:4321").Code)
+}
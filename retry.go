@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Failure classes accepted by --retry-on
+const (
+	retryOnConnect = "connect"
+	retryOnReset   = "reset"
+	retryOnTimeout = "timeout"
+)
+
+// maxRetryBackoff caps the exponential backoff between attempts
+const maxRetryBackoff = 5 * time.Second
+
+func validateRetryClasses(classes []string) error {
+	for _, c := range classes {
+		switch c {
+		case retryOnConnect, retryOnReset, retryOnTimeout, "502", "503", "504":
+		default:
+			return fmt.Errorf("invalid retry class %q (valid: connect, reset, timeout, 502, 503, 504)", c)
+		}
+	}
+	return nil
+}
+
+// upstreamPool rotates requests across the backends of a route
+type upstreamPool struct {
+	targets []*url.URL
+	next    atomic.Uint64
+}
+
+func newUpstreamPool(targets []*url.URL) *upstreamPool {
+	return &upstreamPool{targets: targets}
+}
+
+// pick returns the index of the upstream for a new request
+func (p *upstreamPool) pick() int {
+	return int((p.next.Add(1) - 1) % uint64(len(p.targets)))
+}
+
+// at returns the upstream used for the given attempt, so every retry moves on
+// to a different backend when more than one is configured.
+func (p *upstreamPool) at(start, attempt int) *url.URL {
+	return p.targets[(start+attempt)%len(p.targets)]
+}
+
+// retryTransport balances requests across the route upstreams and replays
+// failed attempts according to the route RetryConfig.
+//
+// Connection failures are retried for every request whose body can be
+// replayed, since the backend never saw the request. Other failure classes
+// are only retried for idempotent requests.
+type retryTransport struct {
+	base      http.RoundTripper
+	cfg       RetryConfig
+	upstreams *upstreamPool
+	routeName string
+}
+
+func newRetryTransport(base http.RoundTripper, cfg RetryConfig, upstreams *upstreamPool, routeName string) *retryTransport {
+	return &retryTransport{
+		base:      base,
+		cfg:       cfg,
+		upstreams: upstreams,
+		routeName: routeName,
+	}
+}
+
+func (rt *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := rt.upstreams.pick()
+
+	attempts := 1
+	var buf []byte
+	body := req.Body
+	if rt.cfg.Attempts > 0 {
+		var replayable bool
+		buf, body, replayable = rt.bufferBody(req)
+		if replayable {
+			attempts += rt.cfg.Attempts
+		}
+	}
+	idempotent := isIdempotentRequest(req)
+
+	for attempt := 0; ; attempt++ {
+		target := rt.upstreams.at(start, attempt)
+
+		// Every attempt works on a clone; a RoundTripper must not modify the
+		// request it was given.
+		outreq := req.Clone(req.Context())
+		outreq.URL.Scheme = target.Scheme
+		outreq.URL.Host = target.Host
+		if buf != nil {
+			outreq.Body = io.NopCloser(bytes.NewReader(buf))
+			outreq.ContentLength = int64(len(buf))
+		} else {
+			outreq.Body = body
+		}
+
+		resp, timedOut, err := rt.roundTripOnce(outreq)
+
+		class := retryClass(resp, err, timedOut)
+		last := attempt+1 >= attempts
+		if class == "" || last || !rt.shouldRetry(class, idempotent) || req.Context().Err() != nil {
+			return resp, err
+		}
+
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+			resp.Body.Close()
+		}
+
+		delay := rt.backoff(attempt)
+		log.Debug().
+			Err(err).
+			Str("route", rt.routeName).
+			Str("upstream", target.Host).
+			Str("reason", class).
+			Int("attempt", attempt+1).
+			Dur("backoff", delay).
+			Msg("Retrying proxy request")
+		trace.SpanFromContext(req.Context()).AddEvent("proxy.retry",
+			trace.WithAttributes(
+				attribute.String("route.name", rt.routeName),
+				attribute.String("retry.upstream", target.Host),
+				attribute.String("retry.reason", class),
+				attribute.Int("retry.attempt", attempt+1),
+			))
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// roundTripOnce performs a single attempt. The per-try timeout only bounds the
+// wait for response headers; the attempt context is released once the
+// response body is closed so streamed bodies are not cut short.
+func (rt *retryTransport) roundTripOnce(req *http.Request) (*http.Response, bool, error) {
+	if rt.cfg.TryTimeout <= 0 || isUpgradeRequest(req) {
+		resp, err := rt.base.RoundTrip(req)
+		return resp, false, err
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(rt.cfg.TryTimeout, cancel)
+	resp, err := rt.base.RoundTrip(req.WithContext(ctx))
+	timedOut := !timer.Stop()
+
+	if err != nil {
+		cancel()
+		return nil, timedOut, err
+	}
+	resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, false, nil
+}
+
+func (rt *retryTransport) shouldRetry(class string, idempotent bool) bool {
+	enabled := false
+	for _, c := range rt.cfg.On {
+		if c == class {
+			enabled = true
+			break
+		}
+	}
+	if !enabled {
+		return false
+	}
+	return class == retryOnConnect || idempotent
+}
+
+func (rt *retryTransport) backoff(attempt int) time.Duration {
+	if rt.cfg.Backoff <= 0 {
+		return 0
+	}
+	d := rt.cfg.Backoff << attempt
+	if d <= 0 || d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	// Jitter in [d/2, d) so retries from concurrent requests spread out.
+	half := int64(d / 2)
+	return time.Duration(half + rand.Int64N(half+1))
+}
+
+// bufferBody reads the request body into memory so it can be replayed. When
+// the body exceeds MaxBody the already read part is stitched back in front of
+// the remainder, which is returned as the body of the only attempt, and the
+// request is reported as not replayable.
+func (rt *retryTransport) bufferBody(req *http.Request) ([]byte, io.ReadCloser, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, req.Body, true
+	}
+	if req.ContentLength > rt.cfg.MaxBody {
+		return nil, req.Body, false
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(req.Body, rt.cfg.MaxBody+1))
+	if err != nil || int64(len(buf)) > rt.cfg.MaxBody {
+		return nil, struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}, false
+	}
+	req.Body.Close()
+	return buf, nil, true
+}
+
+// retryClass maps the outcome of an attempt to the failure class it belongs to
+func retryClass(resp *http.Response, err error, timedOut bool) string {
+	if err != nil {
+		var opErr *net.OpError
+		switch {
+		case timedOut:
+			return retryOnTimeout
+		case errors.As(err, &opErr) && opErr.Op == "dial":
+			return retryOnConnect
+		case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return retryOnReset
+		}
+		return ""
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return strconv.Itoa(resp.StatusCode)
+	}
+	return ""
+}
+
+// isIdempotentRequest reports whether replaying the request is safe even if
+// the backend may already have processed it.
+func isIdempotentRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+func isUpgradeRequest(req *http.Request) bool {
+	return req.Header.Get("Upgrade") != ""
+}
+
+// cancelOnCloseBody releases the attempt context once the proxy is done with
+// the response body.
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
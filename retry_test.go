@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRetryTransport(t *testing.T, cfg RetryConfig, backends ...string) *retryTransport {
+	t.Helper()
+	var targets []*url.URL
+	for _, b := range backends {
+		u, err := url.Parse(b)
+		require.NoError(t, err)
+		targets = append(targets, u)
+	}
+	return newRetryTransport(http.DefaultTransport, cfg, newUpstreamPool(targets), "test")
+}
+
+func TestRetryTransport_RetriesStatus(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	rt := newTestRetryTransport(t, RetryConfig{Attempts: 2, On: []string{"503"}, MaxBody: 1024}, backend.URL)
+
+	req := httptest.NewRequest(http.MethodGet, backend.URL+"/", nil)
+	req.RequestURI = ""
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestRetryTransport_LeavesRequestUntouched(t *testing.T) {
+	var bodies []string
+	handler := func(status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			bodies = append(bodies, string(b))
+			w.WriteHeader(status)
+		}
+	}
+	failing := httptest.NewServer(handler(http.StatusServiceUnavailable))
+	defer failing.Close()
+	healthy := httptest.NewServer(handler(http.StatusOK))
+	defer healthy.Close()
+
+	rt := newTestRetryTransport(t, RetryConfig{Attempts: 1, On: []string{"503"}, MaxBody: 1024}, failing.URL, healthy.URL)
+
+	req := httptest.NewRequest(http.MethodPut, "http://app.internal/items?x=1", strings.NewReader("payload"))
+	req.RequestURI = ""
+	body := req.Body
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []string{"payload", "payload"}, bodies)
+	assert.Equal(t, "http://app.internal/items?x=1", req.URL.String())
+	assert.Equal(t, body, req.Body)
+}
+
+func TestRetryTransport_NonIdempotentNotRetriedOnStatus(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer backend.Close()
+
+	rt := newTestRetryTransport(t, RetryConfig{Attempts: 3, On: []string{"502"}, MaxBody: 1024}, backend.URL)
+
+	req := httptest.NewRequest(http.MethodPost, backend.URL+"/", strings.NewReader("payload"))
+	req.RequestURI = ""
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestRetryTransport_ConnectErrorMovesToNextUpstream(t *testing.T) {
+	var body atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body.Store(string(b))
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	// Grab a free port and close it so dialing it fails.
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+
+	rt := newTestRetryTransport(t, RetryConfig{Attempts: 1, On: []string{"connect"}, MaxBody: 1024}, deadURL, backend.URL)
+
+	req := httptest.NewRequest(http.MethodPost, deadURL+"/", strings.NewReader("payload"))
+	req.RequestURI = ""
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "payload", body.Load())
+}
+
+func TestRetryTransport_OversizedBodyNotRetried(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+
+	rt := newTestRetryTransport(t, RetryConfig{Attempts: 2, On: []string{"connect"}, MaxBody: 4}, deadURL)
+
+	req := httptest.NewRequest(http.MethodPut, deadURL+"/", strings.NewReader("too large"))
+	req.RequestURI = ""
+	_, err := rt.RoundTrip(req)
+	assert.Error(t, err)
+}
+
+func TestRetryTransport_TryTimeout(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	rt := newTestRetryTransport(t, RetryConfig{Attempts: 1, TryTimeout: 50 * time.Millisecond, On: []string{"timeout"}, MaxBody: 1024}, backend.URL)
+
+	req := httptest.NewRequest(http.MethodGet, backend.URL+"/", nil)
+	req.RequestURI = ""
+	resp, err := rt.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(b))
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestIsIdempotentRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		header   string
+		expected bool
+	}{
+		{name: "GET", method: http.MethodGet, expected: true},
+		{name: "PUT", method: http.MethodPut, expected: true},
+		{name: "POST", method: http.MethodPost, expected: false},
+		{name: "POST with idempotency key", method: http.MethodPost, header: "abc", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Idempotency-Key", tt.header)
+			}
+			assert.Equal(t, tt.expected, isIdempotentRequest(req))
+		})
+	}
+}
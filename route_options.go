@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// routeOptionListSeparator separates list values inside a single route option.
+// Commas are already used by the CLI to split repeatable flags.
+const routeOptionListSeparator = "|"
+
+// routeOptionSetters maps each --route-option key to the function applying it.
+// Keys mirror the name of the global flag they override.
+var routeOptionSetters = map[string]func(rc *RouteConfig, value string) error{
+	"upstreams": func(rc *RouteConfig, value string) error {
+		upstreams := splitRouteOptionList(value)
+		for _, u := range upstreams {
+			if err := validateBackendURL(u); err != nil {
+				return err
+			}
+		}
+		rc.Upstreams = upstreams
+		return nil
+	},
+	"dial-via": func(rc *RouteConfig, value string) error {
+		if err := validateDialVia(value); err != nil {
+			return err
+		}
+		rc.DialVia = value
+		return nil
+	},
+	"ephemeral": func(rc *RouteConfig, value string) error {
+		return parseBoolOption(value, &rc.Ephemeral)
+	},
+	"hostname": func(rc *RouteConfig, value string) error {
+		if err := validateNodeHostname(value); err != nil {
+			return err
+		}
+		rc.Node.Hostname = value
+		return nil
+	},
+	"accept-routes": func(rc *RouteConfig, value string) error {
+		return parseBoolOption(value, &rc.Node.AcceptRoutes)
+	},
+	"accept-dns": func(rc *RouteConfig, value string) error {
+		return parseBoolOption(value, &rc.Node.AcceptDNS)
+	},
+	"tags": func(rc *RouteConfig, value string) error {
+		tags := normalizeTags(splitRouteOptionList(value))
+		if err := validateTags(tags); err != nil {
+			return err
+		}
+		rc.Tags = tags
+		return nil
+	},
+	"auth-key": func(rc *RouteConfig, value string) error {
+		rc.AuthKey = AuthKeyConfig{Key: value}
+		return nil
+	},
+	"auth-key-file": func(rc *RouteConfig, value string) error {
+		rc.AuthKey = AuthKeyConfig{File: value}
+		return nil
+	},
+	"state-store": func(rc *RouteConfig, value string) error {
+		if err := validateStateStore(value); err != nil {
+			return err
+		}
+		rc.State.Store = value
+		return nil
+	},
+	"state-kube-secret-prefix": func(rc *RouteConfig, value string) error {
+		rc.State.KubeSecretPrefix = value
+		return nil
+	},
+	"key-expiry-warning": func(rc *RouteConfig, value string) error {
+		return parseDurationOption(value, &rc.KeyExpiry.Warning)
+	},
+	"key-expiry-renew-before": func(rc *RouteConfig, value string) error {
+		return parseDurationOption(value, &rc.KeyExpiry.RenewBefore)
+	},
+	"disable-key-expiry": func(rc *RouteConfig, value string) error {
+		return parseBoolOption(value, &rc.KeyExpiry.Disable)
+	},
+	"stale-device-policy": func(rc *RouteConfig, value string) error {
+		if err := validateStaleDevicePolicy(value); err != nil {
+			return err
+		}
+		rc.StaleDevice.Policy = value
+		return nil
+	},
+	"stale-device-offline-after": func(rc *RouteConfig, value string) error {
+		return parseDurationOption(value, &rc.StaleDevice.OfflineAfter)
+	},
+	"device-reconcile": func(rc *RouteConfig, value string) error {
+		if err := validateReconcilePolicy(value); err != nil {
+			return err
+		}
+		rc.Reconcile = value
+		return nil
+	},
+	"skip-tls-verify": func(rc *RouteConfig, value string) error {
+		return parseBoolOption(value, &rc.UpstreamTLS.SkipVerify)
+	},
+	"upstream-ca-file": func(rc *RouteConfig, value string) error {
+		rc.UpstreamTLS.CAFile = value
+		return nil
+	},
+	"upstream-server-name": func(rc *RouteConfig, value string) error {
+		rc.UpstreamTLS.ServerName = value
+		return nil
+	},
+	"upstream-pin-sha256": func(rc *RouteConfig, value string) error {
+		pins := splitRouteOptionList(value)
+		if err := validateCertPins(pins); err != nil {
+			return err
+		}
+		rc.UpstreamTLS.PinSHA256 = pins
+		return nil
+	},
+	"upstream-client-cert": func(rc *RouteConfig, value string) error {
+		rc.UpstreamTLS.ClientCert = value
+		return nil
+	},
+	"upstream-client-key": func(rc *RouteConfig, value string) error {
+		rc.UpstreamTLS.ClientKey = value
+		return nil
+	},
+	"client-cert-auth": func(rc *RouteConfig, value string) error {
+		if err := validateClientCertAuth(value); err != nil {
+			return err
+		}
+		rc.ClientCert.Mode = value
+		return nil
+	},
+	"client-ca-file": func(rc *RouteConfig, value string) error {
+		rc.ClientCert.CAFile = value
+		return nil
+	},
+	"oidc-issuer": func(rc *RouteConfig, value string) error {
+		rc.OIDC.Issuer = value
+		return nil
+	},
+	"oidc-client-id": func(rc *RouteConfig, value string) error {
+		rc.OIDC.ClientID = value
+		return nil
+	},
+	"oidc-client-secret": func(rc *RouteConfig, value string) error {
+		rc.OIDC.ClientSecret = value
+		return nil
+	},
+	"oidc-redirect-url": func(rc *RouteConfig, value string) error {
+		rc.OIDC.RedirectURL = value
+		return nil
+	},
+	"oidc-scopes": func(rc *RouteConfig, value string) error {
+		rc.OIDC.Scopes = splitRouteOptionList(value)
+		return nil
+	},
+	"oidc-cookie-secret": func(rc *RouteConfig, value string) error {
+		rc.OIDC.CookieSecret = value
+		return nil
+	},
+	"oidc-session-ttl": func(rc *RouteConfig, value string) error {
+		var ttl time.Duration
+		if err := parseDurationOption(value, &ttl); err != nil {
+			return err
+		}
+		if err := validateSessionTTL(ttl); err != nil {
+			return err
+		}
+		rc.OIDC.SessionTTL = ttl
+		return nil
+	},
+	"oidc-groups-claim": func(rc *RouteConfig, value string) error {
+		rc.OIDC.GroupsClaim = value
+		return nil
+	},
+	"oidc-allowed-groups": func(rc *RouteConfig, value string) error {
+		rc.OIDC.AllowedGroups = splitRouteOptionList(value)
+		return nil
+	},
+	"oidc-required-claims": func(rc *RouteConfig, value string) error {
+		claims := splitRouteOptionList(value)
+		if err := validateRequiredClaims(claims); err != nil {
+			return err
+		}
+		rc.OIDC.RequiredClaims = claims
+		return nil
+	},
+	"basic-auth-file": func(rc *RouteConfig, value string) error {
+		rc.Auth.HtpasswdFile = value
+		return nil
+	},
+	"api-keys-file": func(rc *RouteConfig, value string) error {
+		rc.Auth.APIKeysFile = value
+		return nil
+	},
+	"auth-exempt-paths": func(rc *RouteConfig, value string) error {
+		rc.Auth.ExemptPaths = splitRouteOptionList(value)
+		return nil
+	},
+	"auth-exempt-tags": func(rc *RouteConfig, value string) error {
+		rc.Auth.ExemptTags = splitRouteOptionList(value)
+		return nil
+	},
+	"auth-realm": func(rc *RouteConfig, value string) error {
+		rc.Auth.Realm = value
+		return nil
+	},
+	"forward-auth-url": func(rc *RouteConfig, value string) error {
+		if err := validateBackendURL(value); err != nil {
+			return err
+		}
+		rc.ForwardAuth.URL = value
+		return nil
+	},
+	"forward-auth-response-headers": func(rc *RouteConfig, value string) error {
+		rc.ForwardAuth.ResponseHeaders = splitRouteOptionList(value)
+		return nil
+	},
+	"forward-auth-timeout": func(rc *RouteConfig, value string) error {
+		return parseDurationOption(value, &rc.ForwardAuth.Timeout)
+	},
+	"forward-auth-cache-ttl": func(rc *RouteConfig, value string) error {
+		return parseDurationOption(value, &rc.ForwardAuth.CacheTTL)
+	},
+	"retry-attempts": func(rc *RouteConfig, value string) error {
+		return parseIntOption(value, &rc.Retry.Attempts)
+	},
+	"retry-try-timeout": func(rc *RouteConfig, value string) error {
+		return parseDurationOption(value, &rc.Retry.TryTimeout)
+	},
+	"retry-backoff": func(rc *RouteConfig, value string) error {
+		return parseDurationOption(value, &rc.Retry.Backoff)
+	},
+	"retry-on": func(rc *RouteConfig, value string) error {
+		classes := splitRouteOptionList(value)
+		if err := validateRetryClasses(classes); err != nil {
+			return err
+		}
+		rc.Retry.On = classes
+		return nil
+	},
+	"retry-max-body": func(rc *RouteConfig, value string) error {
+		return parseInt64Option(value, &rc.Retry.MaxBody)
+	},
+	"rate-limit": func(rc *RouteConfig, value string) error {
+		return parseFloatOption(value, &rc.RateLimit.Rate)
+	},
+	"rate-limit-burst": func(rc *RouteConfig, value string) error {
+		return parseIntOption(value, &rc.RateLimit.Burst)
+	},
+	"rate-limit-key": func(rc *RouteConfig, value string) error {
+		if err := validateIdentityKey(value); err != nil {
+			return err
+		}
+		rc.RateLimit.Key = value
+		return nil
+	},
+	"max-in-flight": func(rc *RouteConfig, value string) error {
+		return parseIntOption(value, &rc.Concurrency.MaxInFlight)
+	},
+	"max-queue": func(rc *RouteConfig, value string) error {
+		return parseIntOption(value, &rc.Concurrency.MaxQueue)
+	},
+	"queue-timeout": func(rc *RouteConfig, value string) error {
+		return parseDurationOption(value, &rc.Concurrency.QueueTimeout)
+	},
+	"cache": func(rc *RouteConfig, value string) error {
+		return parseBoolOption(value, &rc.Cache.Enabled)
+	},
+	"cache-max-size": func(rc *RouteConfig, value string) error {
+		return parseInt64Option(value, &rc.Cache.MaxSize)
+	},
+	"cache-max-object-size": func(rc *RouteConfig, value string) error {
+		return parseInt64Option(value, &rc.Cache.MaxObjectSize)
+	},
+	"cache-disk-max-size": func(rc *RouteConfig, value string) error {
+		return parseInt64Option(value, &rc.Cache.DiskMaxSize)
+	},
+	"cache-purge-allow": func(rc *RouteConfig, value string) error {
+		rc.Cache.PurgeAllow = splitRouteOptionList(value)
+		return nil
+	},
+	"compression": func(rc *RouteConfig, value string) error {
+		return parseBoolOption(value, &rc.Compression.Enabled)
+	},
+	"compression-encodings": func(rc *RouteConfig, value string) error {
+		encodings := splitRouteOptionList(value)
+		if err := validateCompressionEncodings(encodings); err != nil {
+			return err
+		}
+		rc.Compression.Encodings = encodings
+		return nil
+	},
+	"compression-level": func(rc *RouteConfig, value string) error {
+		if err := validateCompressionLevel(value); err != nil {
+			return err
+		}
+		rc.Compression.Level = value
+		return nil
+	},
+	"compression-min-size": func(rc *RouteConfig, value string) error {
+		return parseIntOption(value, &rc.Compression.MinSize)
+	},
+	"compression-content-types": func(rc *RouteConfig, value string) error {
+		rc.Compression.ContentTypes = splitRouteOptionList(value)
+		return nil
+	},
+	"max-body-size": func(rc *RouteConfig, value string) error {
+		return parseInt64Option(value, &rc.Limits.MaxBodySize)
+	},
+	"max-header-bytes": func(rc *RouteConfig, value string) error {
+		return parseIntOption(value, &rc.Limits.MaxHeaderBytes)
+	},
+	"read-timeout": func(rc *RouteConfig, value string) error {
+		return parseDurationOption(value, &rc.Limits.ReadTimeout)
+	},
+	"write-timeout": func(rc *RouteConfig, value string) error {
+		return parseDurationOption(value, &rc.Limits.WriteTimeout)
+	},
+	"min-transfer-rate": func(rc *RouteConfig, value string) error {
+		return parseInt64Option(value, &rc.Limits.MinTransferRate)
+	},
+	"min-transfer-rate-grace": func(rc *RouteConfig, value string) error {
+		return parseDurationOption(value, &rc.Limits.MinTransferRateGrace)
+	},
+}
+
+// parseRouteOption splits a 'route:key=value' option into its parts
+func parseRouteOption(opt string) (routeName, key, value string, err error) {
+	left, value, ok := strings.Cut(opt, "=")
+	if !ok {
+		return "", "", "", fmt.Errorf("invalid route option %q, must be 'route:key=value'", opt)
+	}
+	routeName, key, ok = strings.Cut(left, ":")
+	routeName = strings.ToLower(strings.TrimSpace(routeName))
+	key = strings.ToLower(strings.TrimSpace(key))
+	if !ok || routeName == "" || key == "" {
+		return "", "", "", fmt.Errorf("invalid route option %q, must be 'route:key=value'", opt)
+	}
+	return routeName, key, strings.TrimSpace(value), nil
+}
+
+// applyRouteOption applies a single override to the route configuration
+func applyRouteOption(rc *RouteConfig, key, value string) error {
+	setter, ok := routeOptionSetters[key]
+	if !ok {
+		return fmt.Errorf("unknown route option %q", key)
+	}
+	if err := setter(rc, value); err != nil {
+		return fmt.Errorf("invalid value for route option %q: %w", key, err)
+	}
+	return nil
+}
+
+func splitRouteOptionList(value string) []string {
+	var out []string
+	for _, v := range strings.Split(value, routeOptionListSeparator) {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func parseBoolOption(value string, dst *bool) error {
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*dst = v
+	return nil
+}
+
+func parseIntOption(value string, dst *int) error {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	if v < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	*dst = v
+	return nil
+}
+
+func parseInt64Option(value string, dst *int64) error {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	if v < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	*dst = v
+	return nil
+}
+
+func parseFloatOption(value string, dst *float64) error {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	if v < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	*dst = v
+	return nil
+}
+
+func parseDurationOption(value string, dst *time.Duration) error {
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if v < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	*dst = v
+	return nil
+}
+
+// validateBackendURL checks that a backend is an absolute http(s) URL
+func validateBackendURL(backend string) error {
+	if !strings.HasPrefix(backend, "http://") && !strings.HasPrefix(backend, "https://") {
+		return fmt.Errorf("backend URL must start with http:// or https://: %s", backend)
+	}
+	if _, err := url.Parse(backend); err != nil {
+		return fmt.Errorf("invalid backend URL %s: %w", backend, err)
+	}
+	return nil
+}
+
+// validateUpstreams checks that extra upstreams share the backend URL path,
+// since retries and balancing only swap the scheme and host of a request.
+func validateUpstreams(backend string, upstreams []string) error {
+	target, err := url.Parse(backend)
+	if err != nil {
+		return fmt.Errorf("invalid backend URL %s: %w", backend, err)
+	}
+	for _, upstream := range upstreams {
+		u, err := url.Parse(upstream)
+		if err != nil {
+			return fmt.Errorf("invalid upstream URL %s: %w", upstream, err)
+		}
+		if strings.TrimSuffix(u.Path, "/") != strings.TrimSuffix(target.Path, "/") {
+			return fmt.Errorf("upstream %s must have the same path as the backend URL (%q)", redactURL(upstream), target.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog/log"
+)
+
+// Route statuses, changed through the admin API
+const (
+	routeStatusActive   = "active"   // Requests are served
+	routeStatusPaused   = "paused"   // New requests are rejected
+	routeStatusDraining = "draining" // New requests are rejected until in-flight ones finish
+	routeStatusStopped  = "stopped"  // The route failed and no longer runs
+)
+
+// routeGate admits requests to a route unless it is paused or draining, and
+// counts the requests in flight
+type routeGate struct {
+	status   atomic.Value // string
+	inFlight atomic.Int64
+}
+
+func newRouteGate() *routeGate {
+	g := &routeGate{}
+	g.status.Store(routeStatusActive)
+	return g
+}
+
+func (g *routeGate) Status() string { return g.status.Load().(string) }
+
+func (g *routeGate) setStatus(status string) { g.status.Store(status) }
+
+// InFlight returns the number of requests being served
+func (g *routeGate) InFlight() int64 { return g.inFlight.Load() }
+
+// middleware rejects requests with 503 while the route does not accept
+// them and tracks the ones it admits.
+func (g *routeGate) middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if g.Status() != routeStatusActive {
+			c.Response().Header().Set("Connection", "close")
+			c.Response().Header().Set("Retry-After", "30")
+			return echo.ErrServiceUnavailable
+		}
+		g.inFlight.Add(1)
+		defer g.inFlight.Add(-1)
+		return next(c)
+	}
+}
+
+// drain stops admitting requests and waits until none are in flight or ctx
+// is done. It returns the number of requests still in flight.
+func (g *routeGate) drain(ctx context.Context) int64 {
+	g.setStatus(routeStatusDraining)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for g.InFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return g.InFlight()
+		case <-ticker.C:
+		}
+	}
+	return 0
+}
+
+// routeHandle is a running route, static or added at runtime
+type routeHandle struct {
+	name    string
+	backend string
+	route   *RouteConfig
+	dynamic bool // Added through the admin API
+	started time.Time
+	gate    *routeGate
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	fqdn     string
+	ip4, ip6 netip.Addr
+	err      error // Why the route stopped
+}
+
+// routeRegistry tracks every managed route. The zero value is ready to use.
+type routeRegistry struct {
+	mu     sync.Mutex
+	routes map[string]*routeHandle
+}
+
+// add registers a route, returning false when its name or node hostname
+// is taken by another route
+func (r *routeRegistry) add(h *routeHandle) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.routes == nil {
+		r.routes = make(map[string]*routeHandle)
+	}
+	hostname := h.route.NodeHostname()
+	for _, other := range r.routes {
+		if other.name == h.name || other.route.NodeHostname() == hostname {
+			return false
+		}
+	}
+	r.routes[h.name] = h
+	return true
+}
+
+// remove forgets a route handle unless the name was registered again
+func (r *routeRegistry) remove(h *routeHandle) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.routes[h.name] == h {
+		delete(r.routes, h.name)
+	}
+}
+
+func (r *routeRegistry) get(name string) *routeHandle {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.routes[name]
+}
+
+// list returns the routes in name order
+func (r *routeRegistry) list() []*routeHandle {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	handles := make([]*routeHandle, 0, len(r.routes))
+	for _, h := range r.routes {
+		handles = append(handles, h)
+	}
+	slices.SortFunc(handles, func(a, b *routeHandle) int { return strings.Compare(a.name, b.name) })
+	return handles
+}
+
+// gate returns the request gate of a route, or nil when it is not managed
+func (r *routeRegistry) gate(name string) *routeGate {
+	if h := r.get(name); h != nil {
+		return h.gate
+	}
+	return nil
+}
+
+// setAddrs records where a route node can be reached once it is connected
+func (r *routeRegistry) setAddrs(name, fqdn string, ip4, ip6 netip.Addr) {
+	h := r.get(name)
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fqdn, h.ip4, h.ip6 = fqdn, ip4, ip6
+}
+
+// launchRoute registers a route and serves it in the route group until it
+// fails, the group is done or the route is removed. Routes added at runtime
+// only log their failures so they cannot take the gateway down.
+func (s *server) launchRoute(route *RouteConfig, backendURL string, dynamic bool) (*routeHandle, error) {
+	if s.routeGroup == nil {
+		return nil, errRoutesNotStarted
+	}
+	ctx, cancel := context.WithCancel(s.routeCtx)
+	h := &routeHandle{
+		name:    route.Name,
+		backend: backendURL,
+		route:   route,
+		dynamic: dynamic,
+		started: time.Now(),
+		gate:    newRouteGate(),
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	if !s.routes.add(h) {
+		cancel()
+		return nil, errRouteExists
+	}
+
+	serve := s.serveRoute
+	if s.runRouteFn != nil {
+		serve = s.runRouteFn
+	}
+	s.routeGroup.Go(func() error {
+		defer close(h.done)
+		err := serve(h.ctx, h.route, h.backend)
+		if h.ctx.Err() != nil && s.routeCtx.Err() == nil {
+			// Removed through the admin API; the name is only released once
+			// the node has shut down.
+			s.routes.remove(h)
+			s.nodeStates.remove(h.name)
+			log.Info().Str("route", h.name).Msg("Route removed")
+			return nil
+		}
+		h.mu.Lock()
+		h.err = err
+		h.mu.Unlock()
+		if err != nil && dynamic {
+			h.gate.setStatus(routeStatusStopped)
+			log.Error().Err(err).Str("route", h.name).Msg("Route added at runtime stopped")
+			return nil
+		}
+		return err
+	})
+	return h, nil
+}
+
+// removeRoute stops a route and waits until it has shut down or ctx is done.
+// The route goroutine forgets the route, so a route that is slow to stop
+// is reported as stopped and keeps its name until it is gone. A route that
+// already failed is forgotten right away.
+func (s *server) removeRoute(ctx context.Context, name string) error {
+	h := s.routes.get(name)
+	if h == nil {
+		return errRouteNotFound
+	}
+	select {
+	case <-h.done:
+		s.routes.remove(h)
+		s.nodeStates.remove(h.name)
+		log.Info().Str("route", h.name).Msg("Route removed")
+		return nil
+	default:
+	}
+	h.gate.setStatus(routeStatusStopped)
+	h.cancel()
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
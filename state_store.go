@@ -0,0 +1,192 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/argon2"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/kubestore"
+	"tailscale.com/ipn/store/mem"
+)
+
+// State store kinds for the tsnet machine state
+const (
+	stateStoreDir       = "dir"       // tailscaled.state in the route tsnet directory
+	stateStoreMem       = "mem"       // In memory only; requires ephemeral nodes
+	stateStoreKube      = "kube"      // One Kubernetes Secret per route
+	stateStoreEncrypted = "encrypted" // AES-GCM encrypted file in the route tsnet directory
+)
+
+// encryptedStateFile is the file name of the encrypted state store
+const encryptedStateFile = "tailscaled.state.enc"
+
+// The encrypted state file starts with a magic string and the argon2id salt
+// the key was derived with, followed by the nonce and the sealed state.
+const (
+	encryptedStateMagic    = "tsgwenc1"
+	encryptedStateSaltSize = 16
+)
+
+// argon2id parameters for the state encryption key
+const (
+	stateKeyTime    = 3
+	stateKeyMemory  = 64 * 1024 // KiB
+	stateKeyThreads = 4
+)
+
+func validateStateStore(kind string) error {
+	switch kind {
+	case stateStoreDir, stateStoreMem, stateStoreKube, stateStoreEncrypted:
+		return nil
+	}
+	return fmt.Errorf("invalid state store %q (valid: dir, mem, kube, encrypted)", kind)
+}
+
+// newStateStore returns the store for a route's machine state. A nil store
+// lets tsnet use its default file store in dir.
+func newStateStore(route *RouteConfig, dir string) (ipn.StateStore, error) {
+	cfg := route.State
+	switch cfg.Store {
+	case stateStoreDir, "":
+		return nil, nil
+	case stateStoreMem:
+		if !route.Ephemeral {
+			return nil, errors.New("the mem state store requires ephemeral nodes (--ephemeral)")
+		}
+		return new(mem.Store), nil
+	case stateStoreKube:
+		secret := cfg.KubeSecretPrefix + route.Name
+		store, err := kubestore.New(func(format string, args ...any) {
+			log.Debug().Str("route", route.Name).Msgf(format, args...)
+		}, secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Kubernetes state store (secret %s): %w", secret, err)
+		}
+		return store, nil
+	case stateStoreEncrypted:
+		if cfg.EncryptionKey == "" {
+			return nil, errors.New("the encrypted state store requires --state-encryption-key")
+		}
+		store, err := newEncryptedStateStore(filepath.Join(dir, encryptedStateFile), cfg.EncryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	return nil, validateStateStore(cfg.Store)
+}
+
+// encryptedStateStore is an ipn.StateStore kept in memory and persisted to a
+// single AES-GCM encrypted file on every write. The key is derived from the
+// secret with argon2id and a random salt stored in the file header.
+type encryptedStateStore struct {
+	path   string
+	header []byte // Magic and salt, authenticated with the state
+	aead   cipher.AEAD
+
+	mu    sync.Mutex
+	state map[ipn.StateKey][]byte
+}
+
+func newEncryptedStateStore(path, secret string) (*encryptedStateStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var salt []byte
+	if data == nil {
+		salt = make([]byte, encryptedStateSaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(data) < len(encryptedStateMagic)+encryptedStateSaltSize || string(data[:len(encryptedStateMagic)]) != encryptedStateMagic {
+			return nil, fmt.Errorf("encrypted state file %s is truncated or has an unknown format", path)
+		}
+		salt = data[len(encryptedStateMagic) : len(encryptedStateMagic)+encryptedStateSaltSize]
+	}
+
+	aead, err := newStateAEAD(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+	s := &encryptedStateStore{
+		path:   path,
+		header: append([]byte(encryptedStateMagic), salt...),
+		aead:   aead,
+		state:  make(map[ipn.StateKey][]byte),
+	}
+	if data == nil {
+		return s, nil
+	}
+
+	sealed := data[len(s.header):]
+	nonceSize := aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("encrypted state file %s is truncated", path)
+	}
+	plain, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], s.header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt state file %s (wrong key?): %w", path, err)
+	}
+	if err := json.Unmarshal(plain, &s.state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// newStateAEAD derives the state encryption key from the secret and salt
+func newStateAEAD(secret string, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey([]byte(secret), salt, stateKeyTime, stateKeyMemory, stateKeyThreads, 32)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *encryptedStateStore) String() string { return fmt.Sprintf("encryptedStateStore(%q)", s.path) }
+
+func (s *encryptedStateStore) ReadState(id ipn.StateKey) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	bs, ok := s.state[id]
+	if !ok {
+		return nil, ipn.ErrStateNotExist
+	}
+	return bs, nil
+}
+
+func (s *encryptedStateStore) WriteState(id ipn.StateKey, bs []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state[id] = append([]byte(nil), bs...)
+
+	plain, err := json.Marshal(s.state)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := s.aead.Seal(nonce, nonce, plain, s.header)
+	data := append(append([]byte(nil), s.header...), sealed...)
+
+	// Write to a temporary file first so a crash never leaves a partial state.
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+)
+
+func TestEncryptedStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), encryptedStateFile)
+	store, err := newEncryptedStateStore(path, "s3cret")
+	require.NoError(t, err)
+
+	_, err = store.ReadState("_machinekey")
+	assert.ErrorIs(t, err, ipn.ErrStateNotExist)
+	require.NoError(t, store.WriteState("_machinekey", []byte("privkey:abc")))
+
+	// The file does not contain the state in clear text.
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "privkey")
+
+	reopened, err := newEncryptedStateStore(path, "s3cret")
+	require.NoError(t, err)
+	got, err := reopened.ReadState("_machinekey")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("privkey:abc"), got)
+
+	_, err = newEncryptedStateStore(path, "wrong")
+	assert.Error(t, err)
+
+	// Every file gets its own salt.
+	other := filepath.Join(t.TempDir(), encryptedStateFile)
+	otherStore, err := newEncryptedStateStore(other, "s3cret")
+	require.NoError(t, err)
+	require.NoError(t, otherStore.WriteState("_machinekey", []byte("privkey:abc")))
+	otherData, err := os.ReadFile(other)
+	require.NoError(t, err)
+	assert.Equal(t, encryptedStateMagic, string(data[:len(encryptedStateMagic)]))
+	assert.NotEqual(t, data[:len(encryptedStateMagic)+encryptedStateSaltSize], otherData[:len(encryptedStateMagic)+encryptedStateSaltSize])
+}
+
+func TestNewStateStore(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := newStateStore(&RouteConfig{Name: "app", State: StateConfig{Store: stateStoreDir}}, dir)
+	require.NoError(t, err)
+	assert.Nil(t, store)
+
+	_, err = newStateStore(&RouteConfig{Name: "app", State: StateConfig{Store: stateStoreMem}}, dir)
+	assert.Error(t, err)
+	store, err = newStateStore(&RouteConfig{Name: "app", Ephemeral: true, State: StateConfig{Store: stateStoreMem}}, dir)
+	require.NoError(t, err)
+	assert.IsType(t, &mem.Store{}, store)
+
+	_, err = newStateStore(&RouteConfig{Name: "app", State: StateConfig{Store: stateStoreEncrypted}}, dir)
+	assert.Error(t, err)
+	store, err = newStateStore(&RouteConfig{Name: "app", State: StateConfig{Store: stateStoreEncrypted, EncryptionKey: "k"}}, dir)
+	require.NoError(t, err)
+	assert.IsType(t, &encryptedStateStore{}, store)
+
+	_, err = newStateStore(&RouteConfig{Name: "app", State: StateConfig{Store: "s3"}}, dir)
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// tagPattern matches a valid ACL tag
+var tagPattern = regexp.MustCompile(`^tag:[A-Za-z][A-Za-z0-9-]*$`)
+
+// tagProbeKeyExpiry is the lifetime of the auth keys minted to check tags;
+// they are deleted right away.
+const tagProbeKeyExpiry = 300
+
+// normalizeTags prepends "tag:" where it is missing and drops empty and
+// duplicate entries.
+func normalizeTags(values []string) []string {
+	var tags []string
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.HasPrefix(v, "tag:") {
+			v = "tag:" + v
+		}
+		if !slices.Contains(tags, v) {
+			tags = append(tags, v)
+		}
+	}
+	return tags
+}
+
+func validateTags(tags []string) error {
+	if len(tags) == 0 {
+		return errors.New("at least one tag is required")
+	}
+	for _, tag := range tags {
+		if !tagPattern.MatchString(tag) {
+			return fmt.Errorf("invalid tag %q (must start with a letter and contain only letters, numbers and dashes)", tag)
+		}
+	}
+	return nil
+}
+
+// mintedTagSets returns the tags of every route that mints its auth keys
+// rather than using a pre-generated one, in route name order.
+func mintedTagSets(config *Config) ([][]string, error) {
+	names := slices.Sorted(maps.Keys(config.Routes))
+	var tagSets [][]string
+	for _, name := range names {
+		route, err := config.RouteConfig(name)
+		if err != nil {
+			return nil, err
+		}
+		if route.AuthKey == (AuthKeyConfig{}) {
+			tagSets = append(tagSets, route.Tags)
+		}
+	}
+	return tagSets, nil
+}
+
+// checkMintableTags verifies that the OAuth client may create auth keys for
+// every tag set by minting a short-lived key for each and deleting it again.
+// Tags the API rejects are an error; a failure to reach the API is only
+// logged so a temporary outage does not keep routes with state down.
+func checkMintableTags(ctx context.Context, tsClient *tailscale.Client, tagSets [][]string) error {
+	var checked [][]string
+	for _, tags := range tagSets {
+		if slices.ContainsFunc(checked, func(c []string) bool { return slices.Equal(c, tags) }) {
+			continue
+		}
+		checked = append(checked, tags)
+
+		var req tailscale.CreateKeyRequest
+		req.Capabilities.Devices.Create.Tags = tags
+		req.Capabilities.Devices.Create.Preauthorized = true
+		req.ExpirySeconds = tagProbeKeyExpiry
+		req.Description = "TSGW tag check"
+
+		key, err := tsClient.Keys().CreateAuthKey(ctx, req)
+		if err != nil {
+			var apiErr tailscale.APIError
+			if errors.As(err, &apiErr) {
+				return fmt.Errorf("OAuth client cannot create auth keys with tags %s (are they owned by the client's tags in the ACL tagOwners?): %w", strings.Join(tags, ","), err)
+			}
+			log.Warn().Err(err).Strs("tags", tags).Msg("Failed to check tags against the Tailscale API")
+			continue
+		}
+		if err := tsClient.Keys().Delete(ctx, key.ID); err != nil {
+			log.Warn().Err(err).Str("key_id", key.ID).Msg("Failed to delete tag check auth key")
+		}
+		log.Debug().Strs("tags", tags).Msg("Tags can be minted")
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/v2"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	assert.Equal(t, []string{"tag:tsgw", "tag:prod-db"}, normalizeTags([]string{"tsgw", " tag:prod-db ", "", "tag:tsgw"}))
+	assert.Empty(t, normalizeTags(nil))
+}
+
+func TestValidateTags(t *testing.T) {
+	assert.NoError(t, validateTags([]string{"tag:tsgw", "tag:prod-db2"}))
+	assert.Error(t, validateTags(nil))
+	assert.Error(t, validateTags([]string{"tag:1db"}))
+	assert.Error(t, validateTags([]string{"tag:prod_db"}))
+	assert.Error(t, validateTags([]string{"tag:"}))
+}
+
+func TestConfig_RouteConfig_Tags(t *testing.T) {
+	config := &Config{
+		Tags: []string{"tag:tsgw"},
+		RouteOptions: map[string]map[string]string{
+			"db":  {"tags": "tsgw|tag:prod-db"},
+			"bad": {"tags": "prod db"},
+		},
+	}
+
+	rc, err := config.RouteConfig("app")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tag:tsgw"}, rc.Tags)
+
+	rc, err = config.RouteConfig("db")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tag:tsgw", "tag:prod-db"}, rc.Tags)
+	assert.Equal(t, []string{"tag:tsgw"}, config.Tags, "defaults must not be modified")
+
+	_, err = config.RouteConfig("bad")
+	assert.Error(t, err)
+}
+
+func TestMintedTagSets(t *testing.T) {
+	config := &Config{
+		Tags:   []string{"tag:tsgw"},
+		Routes: map[string]string{"web": "http://web", "db": "http://db", "nas": "http://nas"},
+		RouteOptions: map[string]map[string]string{
+			"db":  {"tags": "tag:tsgw|tag:prod-db"},
+			"nas": {"auth-key": "tskey-auth-nas"},
+		},
+	}
+
+	tagSets, err := mintedTagSets(config)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"tag:tsgw", "tag:prod-db"}, {"tag:tsgw"}}, tagSets)
+}
+
+func TestCheckMintableTags(t *testing.T) {
+	var created [][]string
+	var deleted []string
+	client := newFakeTailscaleAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var body tailscale.CreateKeyRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			tags := body.Capabilities.Devices.Create.Tags
+			created = append(created, tags)
+			if tags[len(tags)-1] == "tag:unowned" {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string]string{"message": "requested tags [tag:unowned] are invalid or not permitted"})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]string{"id": "k" + tags[len(tags)-1], "key": "tskey-auth-probe"})
+		case http.MethodDelete:
+			deleted = append(deleted, r.URL.Path)
+		}
+	})
+
+	tagSets := [][]string{{"tag:tsgw"}, {"tag:tsgw", "tag:prod-db"}, {"tag:tsgw"}}
+	require.NoError(t, checkMintableTags(context.Background(), client, tagSets))
+	assert.Equal(t, [][]string{{"tag:tsgw"}, {"tag:tsgw", "tag:prod-db"}}, created, "each tag set is checked once")
+	assert.Equal(t, []string{"/api/v2/tailnet/-/keys/ktag:tsgw", "/api/v2/tailnet/-/keys/ktag:prod-db"}, deleted)
+
+	err := checkMintableTags(context.Background(), client, [][]string{{"tag:tsgw", "tag:unowned"}})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tag:unowned")
+}
+
+func TestCheckMintableTags_Unreachable(t *testing.T) {
+	client := newFakeTailscaleAPI(t, func(w http.ResponseWriter, r *http.Request) {})
+	client.BaseURL.Host = "127.0.0.1:1"
+
+	// A failure to reach the API does not keep routes with state down.
+	assert.NoError(t, checkMintableTags(context.Background(), client, [][]string{{"tag:tsgw"}}))
+}
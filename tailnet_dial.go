@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// How a route reaches its backends
+const (
+	dialViaHost    = "host"    // The host network stack
+	dialViaTailnet = "tailnet" // The route's own Tailscale node
+)
+
+func validateDialVia(value string) error {
+	switch value {
+	case dialViaHost, dialViaTailnet:
+		return nil
+	}
+	return fmt.Errorf("invalid dial-via %q (valid: host, tailnet)", value)
+}
+
+// dialFunc dials a backend connection
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// tailnetDial returns the dialer reaching backends through the route node,
+// which resolves MagicDNS names and tailnet IPs like any other peer would.
+func (rs *RouteServer) tailnetDial() (dialFunc, error) {
+	if rs.tailnetDialFn != nil {
+		return rs.tailnetDialFn, nil
+	}
+	if rs.Server == nil {
+		return nil, errors.New("dialing backends via the tailnet requires a Tailscale node")
+	}
+	return rs.Server.Dial, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProxyTransport_DialViaTailnet(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "from "+r.Host)
+	}))
+	defer backend.Close()
+
+	// The fake tailnet reaches every address through the test backend.
+	var dialed []string
+	rs := &RouteServer{
+		RouteName: "nas",
+		config:    &Config{},
+		route:     &RouteConfig{Name: "nas", DialVia: dialViaTailnet},
+		tailnetDialFn: func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed = append(dialed, address)
+			var d net.Dialer
+			return d.DialContext(ctx, network, backend.Listener.Addr().String())
+		},
+	}
+
+	target, err := url.Parse("http://nas.tailnet.ts.net:5000")
+	require.NoError(t, err)
+	transport, err := rs.newProxyTransport([]*url.URL{target})
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "http://nas.tailnet.ts.net:5000/", nil)
+	req.RequestURI = ""
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, "from nas.tailnet.ts.net:5000", string(body))
+	assert.Equal(t, []string{"nas.tailnet.ts.net:5000"}, dialed)
+	assert.Nil(t, transport.(*http.Transport).Proxy, "proxy env vars cannot reach tailnet backends")
+}
+
+func TestNewProxyTransport_DialViaTailnetWithoutNode(t *testing.T) {
+	rs := &RouteServer{
+		RouteName: "nas",
+		config:    &Config{},
+		route:     &RouteConfig{Name: "nas", DialVia: dialViaTailnet},
+	}
+	_, err := rs.newProxyTransport(nil)
+	assert.Error(t, err)
+
+	rs.route.DialVia = dialViaHost
+	_, err = rs.newProxyTransport(nil)
+	assert.NoError(t, err)
+}
+
+func TestValidateDialVia(t *testing.T) {
+	assert.NoError(t, validateDialVia(dialViaHost))
+	assert.NoError(t, validateDialVia(dialViaTailnet))
+	assert.Error(t, validateDialVia("socks"))
+
+	config := &Config{DialVia: dialViaHost, RouteOptions: map[string]map[string]string{
+		"nas": {"dial-via": "tailnet"},
+		"bad": {"dial-via": "socks"},
+	}}
+	rc, err := config.RouteConfig("nas")
+	require.NoError(t, err)
+	assert.Equal(t, dialViaTailnet, rc.DialVia)
+	_, err = config.RouteConfig("bad")
+	assert.Error(t, err)
+}
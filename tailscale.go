@@ -2,16 +2,23 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 	"golang.org/x/sync/errgroup"
 	"tailscale.com/client/tailscale/v2"
 	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
 )
 
 func (s *server) Start(ctx context.Context) error {
@@ -22,12 +29,27 @@ func (s *server) Start(ctx context.Context) error {
 
 	// Create errgroup for managing all goroutines
 	g, gctx := errgroup.WithContext(ctx)
+	s.routeGroup, s.routeCtx = g, gctx
 
 	// Start independent goroutines for each route
 	for routeName, backendURL := range s.config.Routes {
-		routeName, backendURL := routeName, backendURL // capture loop variables
+		route, err := s.config.RouteConfig(routeName)
+		if err == nil {
+			err = validateUpstreams(backendURL, route.Upstreams)
+		}
+		if err == nil {
+			_, err = s.launchRoute(route, backendURL, false)
+		}
+		if err != nil {
+			g.Go(func() error { return err })
+			break
+		}
+	}
+
+	// The admin API keeps the group running even without routes.
+	if s.config.Admin.Listen != "" || s.config.Admin.Hostname != "" {
 		g.Go(func() error {
-			return s.startRoute(gctx, routeName, backendURL)
+			return s.runAdmin(gctx)
 		})
 	}
 
@@ -40,7 +62,36 @@ func (s *server) Start(ctx context.Context) error {
 // 2. Creates a dedicated Echo instance for this route
 // 3. Starts the HTTP server
 // 4. Listens for shutdown signals
+// When the node is logged out, expired or deleted while running, the route
+// state is reset and the route is started again with a fresh node.
 func (s *server) startRoute(ctx context.Context, routeName, backendURL string) error {
+	route, err := s.config.RouteConfig(routeName)
+	if err != nil {
+		return err
+	}
+	return s.serveRoute(ctx, route, backendURL)
+}
+
+// serveRoute runs a route, recovering it with a fresh node whenever the
+// node needs a new login, until ctx is done or the route fails.
+func (s *server) serveRoute(ctx context.Context, route *RouteConfig, backendURL string) error {
+	routeName := route.Name
+	for {
+		err := s.runRoute(ctx, route, backendURL)
+		if !errors.Is(err, errRouteStateInvalid) || ctx.Err() != nil {
+			return err
+		}
+		log.Warn().Str("route", routeName).Msg("Recovering route with a fresh node")
+		if err := resetRouteState(route, filepath.Join(s.config.TsnetDir, routeName)); err != nil {
+			log.Warn().Err(err).Str("route", routeName).Msg("Could not reset route state; re-authenticating with the existing state")
+		}
+	}
+}
+
+// runRoute runs one node of a route until ctx is done or the node needs a
+// new login, in which case errRouteStateInvalid is returned.
+func (s *server) runRoute(ctx context.Context, route *RouteConfig, backendURL string) error {
+	routeName := route.Name
 	ctx, span := s.otel.Tracer.Start(ctx, "startRoute",
 		trace.WithAttributes(
 			attribute.String("route.name", routeName),
@@ -50,25 +101,94 @@ func (s *server) startRoute(ctx context.Context, routeName, backendURL string) e
 
 	log.Info().Str("route", routeName).Str("backend", backendURL).Msg("Starting route")
 
-	fqdn := routeName + "." + s.config.TailscaleDomain
+	fqdn := route.NodeHostname() + "." + s.config.TailscaleDomain
 
-	tsServer, err := s.startTailscaleInstance(ctx, routeName)
+	s.nodeStates.set(routeName, ipn.Starting)
+	tsServer, err := s.startTailscaleInstance(ctx, route)
 	if err != nil {
 		return fmt.Errorf("failed to start Tailscale instance for %s: %w", routeName, err)
 	}
+
+	// Ephemeral devices are removed once the node is closed, so a restart
+	// without state does not leave a stale device behind.
+	var nodeID tailcfg.StableNodeID
+	if route.Ephemeral {
+		defer func() {
+			if ctx.Err() == nil || nodeID == "" {
+				return
+			}
+			deleteCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			deleteDevice(deleteCtx, s.tsClient, routeName, nodeID)
+		}()
+	}
 	defer tsServer.Close()
 
 	// Get Tailscale IP addresses
 	ip4, ip6 := tsServer.TailscaleIPs()
-	log.Info().Str("route", routeName).Str("ip4", ip4.String()).Str("ip6", ip6.String()).Str("fqdn", fqdn).Msg("Tailscale server connected")
+	log.Info().Str("route", routeName).Str("ip4", ip4.String()).Str("ip6", ip6.String()).Str("fqdn", fqdn).Bool("ephemeral", route.Ephemeral).Msg("Tailscale server connected")
+	s.routes.setAddrs(routeName, fqdn, ip4, ip6)
+
+	lc, err := tsServer.LocalClient()
+	if err != nil {
+		return fmt.Errorf("failed to get local client for %s: %w", routeName, err)
+	}
+
+	if st, err := lc.StatusWithoutPeers(ctx); err == nil && st.Self != nil {
+		nodeID = st.Self.ID
+	}
+	if route.Ephemeral && nodeID == "" {
+		log.Warn().Str("route", routeName).Msg("Could not resolve node ID; the ephemeral device will only expire through inactivity")
+	}
 
-	routeServer, err := NewRouteServer(routeName, tsServer, backendURL, s.config, s.otel)
+	routeServer, err := newRouteServer(&RouteServer{
+		RouteName: routeName,
+		Server:    tsServer,
+		Backend:   backendURL,
+		config:    s.config,
+		route:     route,
+		otel:      s.otel,
+		gate:      s.routes.gate(routeName),
+	})
 	if err != nil {
 		return fmt.Errorf("failed to create route server for %s: %w", routeName, err)
 	}
 
-	// Start the HTTP server for this route
-	return routeServer.Start(ctx)
+	// Serve until shutdown, or until the node needs a new login.
+	routeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	keyExpiry := newKeyExpiryMonitor(route, lc, s.tsClient, func(ctx context.Context) error {
+		return s.loginWithNewKey(ctx, lc, route)
+	}, s.otel.meter())
+	go keyExpiry.run(routeCtx, keyExpiryCheckInterval)
+	go runDeviceReconcile(routeCtx, s.tsClient, route, nodeID, deviceReconcileInterval)
+
+	watcher := newNodeStateWatcher(routeName, lc, &s.nodeStates, s.otel.meter())
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- watcher.run(routeCtx)
+		cancel()
+	}()
+
+	if err := routeServer.Start(routeCtx); err != nil {
+		return err
+	}
+	cancel()
+	return <-watchErr
+}
+
+// deleteDevice removes a route device from the tailnet. Failures are only
+// logged; ephemeral devices are also removed by the control plane after
+// some inactivity.
+func deleteDevice(ctx context.Context, tsClient *tailscale.Client, routeName string, nodeID tailcfg.StableNodeID) {
+	if tsClient == nil {
+		return
+	}
+	if err := tsClient.Devices().Delete(ctx, string(nodeID)); err != nil {
+		log.Warn().Err(err).Str("route", routeName).Str("node_id", string(nodeID)).Msg("Failed to delete ephemeral device")
+		return
+	}
+	log.Info().Str("route", routeName).Str("node_id", string(nodeID)).Msg("Deleted ephemeral device")
 }
 
 // createTailscaleClient creates and returns a Tailscale API client
@@ -87,17 +207,25 @@ func createTailscaleClient(ctx context.Context, config *Config) (*tailscale.Clie
 		log.Info().Str("issuer", config.OAuth.Issuer).Msg("Using custom OAuth issuer")
 	}
 
-	credentials := clientcredentials.Config{
-		ClientID:     config.OAuth.ClientID,
-		ClientSecret: config.OAuth.ClientSecret,
-		TokenURL:     tokenURL,
+	var httpClient *http.Client
+	if config.OAuth.IDTokenFile != "" {
+		exchangeURL := strings.TrimSuffix(tokenURL, tokenURLPath) + tokenExchangeURLPath
+		log.Info().Str("id_token_file", config.OAuth.IDTokenFile).Msg("Using workload identity federation for the Tailscale API")
+		httpClient = oauth2.NewClient(ctx, newFederatedTokenSource(ctx, config.OAuth.ClientID, config.OAuth.IDTokenFile, exchangeURL))
+	} else {
+		credentials := clientcredentials.Config{
+			ClientID:     config.OAuth.ClientID,
+			ClientSecret: config.OAuth.ClientSecret,
+			TokenURL:     tokenURL,
+		}
+		httpClient = credentials.Client(ctx)
 	}
 
 	// Create Tailscale API client
 	tsClient := &tailscale.Client{
 		BaseURL:   baseURL,
 		Tailnet:   "-", // "-" indicates default tailnet
-		HTTP:      credentials.Client(ctx),
+		HTTP:      httpClient,
 		UserAgent: "tsgw",
 	}
 
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -11,16 +12,18 @@ import (
 	"github.com/rs/zerolog/log"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
+	"tailscale.com/client/local"
 	"tailscale.com/client/tailscale/v2"
 	"tailscale.com/ipn"
 	"tailscale.com/tsnet"
 )
 
-func (s *server) startTailscaleInstance(ctx context.Context, routeName string) (*tsnet.Server, error) {
+func (s *server) startTailscaleInstance(ctx context.Context, route *RouteConfig) (*tsnet.Server, error) {
+	routeName := route.Name
 	ctx, span := s.otel.Tracer.Start(ctx, "startTailscaleInstance",
 		trace.WithAttributes(
 			attribute.String("route.name", routeName),
-			attribute.String("route.fqdn", routeName+"."+s.config.TailscaleDomain),
+			attribute.String("route.fqdn", route.NodeHostname()+"."+s.config.TailscaleDomain),
 		))
 	defer span.End()
 
@@ -33,10 +36,34 @@ func (s *server) startTailscaleInstance(ctx context.Context, routeName string) (
 		}
 	}
 
+	tsServer, err := s.startNode(ctx, route, tsnetDir, true)
+	if errors.Is(err, errRouteStateInvalid) {
+		if err := resetRouteState(route, tsnetDir); err != nil {
+			log.Warn().Err(err).Str("route", routeName).Msg("Could not reset route state; re-authenticating with the existing state")
+		}
+		tsServer, err = s.startNode(ctx, route, tsnetDir, false)
+	}
+	return tsServer, err
+}
+
+// startNode starts the route node and logs it in with a new auth key when
+// needed. With recoverState, a node whose previous state was logged out,
+// expired or deleted is closed and errRouteStateInvalid is returned instead.
+func (s *server) startNode(ctx context.Context, route *RouteConfig, tsnetDir string, recoverState bool) (*tsnet.Server, error) {
+	routeName := route.Name
+	store, err := newStateStore(route, tsnetDir)
+	if err != nil {
+		return nil, err
+	}
+	hadState := hasRouteState(store, tsnetDir)
+
 	// Try to start without auth key first
 	tsServer := &tsnet.Server{
-		Hostname: routeName,
-		Dir:      tsnetDir,
+		Hostname:   route.NodeHostname(),
+		Dir:        tsnetDir,
+		Store:      store,
+		ControlURL: s.config.ControlURL,
+		Ephemeral:  route.Ephemeral,
 		UserLogf: func(format string, args ...interface{}) {
 			log.Debug().Str("route", routeName).Msgf(format, args...)
 		},
@@ -45,6 +72,8 @@ func (s *server) startTailscaleInstance(ctx context.Context, routeName string) (
 		},
 	}
 
+	// Start and LocalClient failures (permissions, ports, ...) say nothing
+	// about the state, so they never reset it.
 	if err := tsServer.Start(); err != nil {
 		log.Warn().Err(err).Str("route", routeName).Msg("Failed to start with existing state")
 		return nil, err
@@ -55,7 +84,13 @@ func (s *server) startTailscaleInstance(ctx context.Context, routeName string) (
 	if err != nil {
 		log.Warn().Err(err).Str("route", routeName).Msg("Failed to create local client for existing state")
 		tsServer.Close()
-		//TODO: cleanup state files?
+		return nil, err
+	}
+
+	// Prefs are applied before logging in, so a new node never runs with
+	// the defaults.
+	if err := applyNodePrefs(ctx, lc, route); err != nil {
+		tsServer.Close()
 		return nil, err
 	}
 
@@ -79,22 +114,20 @@ waitOnline:
 			if loginDone {
 				break
 			}
-
-			key, err := createNewAuthKey(ctx, s.tsClient, s.config.TailscaleTag, routeName)
-			if err != nil {
+			if shouldResetState(st.BackendState, hadState, recoverState) {
+				log.Warn().Str("route", routeName).Msg("Existing route state needs a new login; the node was logged out, expired or deleted")
 				tsServer.Close()
-				return nil, err
+				return nil, errRouteStateInvalid
 			}
 
-			log.Info().Str("route", routeName).Msg("Logging in with new auth key")
-			if err := lc.Start(ctx, ipn.Options{AuthKey: key}); err != nil {
-				log.Warn().Err(err).Str("route", routeName).Msg("Failed to authenticate with new auth key")
+			// Without state the node registers as a new device; offline
+			// leftovers would push it to routeName-1.
+			if err := handleStaleDevices(ctx, s.tsClient, route, s.reservedHostnames(route.Name)); err != nil {
 				tsServer.Close()
 				return nil, err
 			}
 
-			if err := lc.StartLoginInteractive(ctx); err != nil {
-				log.Warn().Err(err).Str("route", routeName).Msg("Failed to start interactive login")
+			if err := s.loginWithNewKey(ctx, lc, route); err != nil {
 				tsServer.Close()
 				return nil, err
 			}
@@ -111,19 +144,64 @@ waitOnline:
 	// Try to connect. Use ctx so shutdown can interrupt this.
 	upCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
-	_, connectErr := tsServer.Up(upCtx)
+	st, connectErr := tsServer.Up(upCtx)
 	if connectErr != nil {
 		log.Warn().Err(connectErr).Str("route", routeName).Msg("Failed to connect")
 		tsServer.Close()
 		return nil, connectErr
 	}
 
+	expected := route.NodeHostname() + "." + s.config.TailscaleDomain
+	if st.Self != nil {
+		obtained := strings.TrimSuffix(st.Self.DNSName, ".")
+		if obtained != "" && !strings.EqualFold(obtained, expected) {
+			log.Warn().Str("route", routeName).Str("fqdn", obtained).Str("expected_fqdn", expected).Msg("Route node registered under a different name; URLs using the expected name will not resolve")
+		} else {
+			log.Info().Str("route", routeName).Str("fqdn", obtained).Msg("Route node registered")
+		}
+	}
+
 	return tsServer, nil
 }
 
+// authKeys returns where a route gets its auth keys from: its pre-generated
+// key when one is configured, the configured provider otherwise.
+func (s *server) authKeys(route *RouteConfig) authKeyProvider {
+	if route.AuthKey != (AuthKeyConfig{}) {
+		return staticKeyProvider(route.AuthKey)
+	}
+	if s.keys == nil {
+		return &tailscaleKeyProvider{client: s.tsClient}
+	}
+	return s.keys
+}
+
+// loginWithNewKey logs the route node in with a freshly created auth key.
+// On a node that is already logged in this re-authenticates it, which
+// renews its key expiry.
+func (s *server) loginWithNewKey(ctx context.Context, lc *local.Client, route *RouteConfig) error {
+	routeName := route.Name
+	key, err := s.authKeys(route).createAuthKey(ctx, authKeyRequest{RouteName: routeName, Tags: route.Tags, Ephemeral: route.Ephemeral})
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("route", routeName).Msg("Logging in with new auth key")
+	if err := lc.Start(ctx, ipn.Options{AuthKey: key}); err != nil {
+		log.Warn().Err(err).Str("route", routeName).Msg("Failed to authenticate with new auth key")
+		return err
+	}
+
+	if err := lc.StartLoginInteractive(ctx); err != nil {
+		log.Warn().Err(err).Str("route", routeName).Msg("Failed to start interactive login")
+		return err
+	}
+	return nil
+}
+
 // createNewAuthKey creates a new auth key for the given hostname
-func createNewAuthKey(ctx context.Context, tsClient *tailscale.Client, tsTag string, routeName string) (string, error) {
-	log.Info().Str("route", routeName).Msg("Creating auth key programmatically")
+func createNewAuthKey(ctx context.Context, tsClient *tailscale.Client, tags []string, routeName string, ephemeral bool) (string, error) {
+	log.Info().Str("route", routeName).Strs("tags", tags).Bool("ephemeral", ephemeral).Msg("Creating auth key programmatically")
 
 	caps := tailscale.KeyCapabilities{
 		Devices: struct {
@@ -141,8 +219,9 @@ func createNewAuthKey(ctx context.Context, tsClient *tailscale.Client, tsTag str
 				Preauthorized bool     `json:"preauthorized"`
 			}{
 				Reusable:      false,
+				Ephemeral:     ephemeral,
 				Preauthorized: true,
-				Tags:          []string{tsTag}, // Tag for our gateway nodes
+				Tags:          tags, // Tags for our gateway nodes
 			},
 		},
 	}
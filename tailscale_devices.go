@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"tailscale.com/client/tailscale/v2"
+)
+
+// What to do with offline devices holding a route hostname before a new
+// node logs in
+const (
+	staleDevicePolicyOff    = "off"
+	staleDevicePolicyWarn   = "warn"   // Log the devices and continue
+	staleDevicePolicyDelete = "delete" // Delete them so the route reclaims its name
+	staleDevicePolicyFail   = "fail"   // Refuse to start the route
+)
+
+func validateStaleDevicePolicy(policy string) error {
+	switch policy {
+	case staleDevicePolicyOff, staleDevicePolicyWarn, staleDevicePolicyDelete, staleDevicePolicyFail:
+		return nil
+	}
+	return fmt.Errorf("invalid stale device policy %q (valid: off, warn, delete, fail)", policy)
+}
+
+// findStaleDevices returns the devices registered with a route hostname and
+// the route tags that were last seen at least offlineAfter ago. Devices whose
+// name belongs to another route (reserved) are never considered, even if
+// the control plane suffixed it with -N.
+func findStaleDevices(devices []tailscale.Device, hostname string, tags, reserved []string, offlineAfter time.Duration, now time.Time) []tailscale.Device {
+	var stale []tailscale.Device
+	for _, d := range devices {
+		if d.Hostname != hostname {
+			continue
+		}
+		if label, _, _ := strings.Cut(d.Name, "."); label != hostname && slices.Contains(reserved, label) {
+			continue
+		}
+		if !containsAll(d.Tags, tags) {
+			continue
+		}
+		if d.LastSeen.IsZero() || now.Sub(d.LastSeen.Time) < offlineAfter {
+			continue
+		}
+		stale = append(stale, d)
+	}
+	return stale
+}
+
+// containsAll reports whether have contains every element of want
+func containsAll(have, want []string) bool {
+	for _, w := range want {
+		if !slices.Contains(have, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// reservedHostnames returns the node hostnames of every route and of the
+// admin node except the given route
+func (s *server) reservedHostnames(except string) []string {
+	var names []string
+	for name := range s.config.Routes {
+		if name == except {
+			continue
+		}
+		if rc, err := s.config.RouteConfig(name); err == nil {
+			names = append(names, rc.NodeHostname())
+		} else {
+			names = append(names, name)
+		}
+	}
+	for _, h := range s.routes.list() {
+		if h.name != except {
+			names = append(names, h.route.NodeHostname())
+		}
+	}
+	if s.config.Admin.Hostname != "" {
+		names = append(names, s.config.Admin.Hostname)
+	}
+	return names
+}
+
+// handleStaleDevices applies the stale device policy for a route about to
+// log in as a new node. Only the fail policy returns an error; with the other
+// policies API failures are logged so a missing devices scope does not keep
+// the route down. Devices named after the reserved hostnames are left alone.
+func handleStaleDevices(ctx context.Context, tsClient *tailscale.Client, route *RouteConfig, reserved []string) error {
+	if tsClient == nil || route.StaleDevice.Policy == staleDevicePolicyOff {
+		return nil
+	}
+	routeName := route.Name
+
+	devices, err := tsClient.Devices().List(ctx)
+	if err != nil {
+		if route.StaleDevice.Policy == staleDevicePolicyFail {
+			return fmt.Errorf("failed to list devices for stale device check: %w", err)
+		}
+		log.Warn().Err(err).Str("route", routeName).Msg("Failed to list devices; skipping stale device check")
+		return nil
+	}
+	stale := findStaleDevices(devices, route.NodeHostname(), route.Tags, reserved, route.StaleDevice.OfflineAfter, time.Now())
+	if len(stale) == 0 {
+		return nil
+	}
+
+	for _, d := range stale {
+		logger := log.With().Str("route", routeName).Str("device", d.Name).Str("node_id", d.NodeID).Time("last_seen", d.LastSeen.Time).Logger()
+		switch route.StaleDevice.Policy {
+		case staleDevicePolicyDelete:
+			if err := tsClient.Devices().Delete(ctx, d.NodeID); err != nil {
+				logger.Warn().Err(err).Msg("Failed to delete stale device")
+				continue
+			}
+			logger.Info().Msg("Deleted stale device")
+		default:
+			logger.Warn().Msg("Found stale device for route hostname")
+		}
+	}
+
+	if route.StaleDevice.Policy == staleDevicePolicyFail {
+		return fmt.Errorf("found %d stale device(s) for route %s; remove them or use --stale-device-policy=delete", len(stale), routeName)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"tailscale.com/client/tailscale/v2"
+)
+
+func testDevice(nodeID, name, hostname string, lastSeen time.Time, tags ...string) tailscale.Device {
+	return tailscale.Device{
+		NodeID:   nodeID,
+		Name:     name,
+		Hostname: hostname,
+		Tags:     tags,
+		LastSeen: tailscale.Time{Time: lastSeen},
+	}
+}
+
+func TestFindStaleDevices(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-time.Hour)
+	devices := []tailscale.Device{
+		testDevice("n1", "app.tail.ts.net", "app", old, "tag:gw"),
+		testDevice("n2", "app-1.tail.ts.net", "app", old, "tag:gw"),
+		testDevice("n3", "app-2.tail.ts.net", "app", now, "tag:gw"),       // online
+		testDevice("n4", "app.tail.ts.net", "app", old, "tag:other"),      // other tag
+		testDevice("n5", "apple.tail.ts.net", "apple", old, "tag:gw"),     // other route
+		testDevice("n6", "app-web.tail.ts.net", "app-web", old, "tag:gw"), // other route
+		testDevice("n7", "app-3.tail.ts.net", "app", old, "tag:db", "tag:gw"),
+		testDevice("n8", "app-2.tail.ts.net", "app-2", old, "tag:gw"), // route app-2
+		testDevice("n9", "app-4.tail.ts.net", "app", old, "tag:gw"),   // named like route app-4
+	}
+
+	stale := findStaleDevices(devices, "app", []string{"tag:gw"}, []string{"app-2", "app-4"}, time.Minute, now)
+	var ids []string
+	for _, d := range stale {
+		ids = append(ids, d.NodeID)
+	}
+	assert.Equal(t, []string{"n1", "n2", "n7"}, ids)
+
+	// Devices must carry every route tag.
+	stale = findStaleDevices(devices, "app", []string{"tag:gw", "tag:db"}, nil, time.Minute, now)
+	require.Len(t, stale, 1)
+	assert.Equal(t, "n7", stale[0].NodeID)
+}
+
+func TestHandleStaleDevices(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	tests := []struct {
+		policy      string
+		wantDeleted []string
+		wantErr     bool
+	}{
+		{policy: staleDevicePolicyOff},
+		{policy: staleDevicePolicyWarn},
+		{policy: staleDevicePolicyDelete, wantDeleted: []string{"/api/v2/device/n1", "/api/v2/device/n2"}},
+		{policy: staleDevicePolicyFail, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			var listed bool
+			var deleted []string
+			client := newFakeTailscaleAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					listed = true
+					assert.Equal(t, "/api/v2/tailnet/-/devices", r.URL.Path)
+					_ = json.NewEncoder(w).Encode(map[string]any{"devices": []tailscale.Device{
+						testDevice("n1", "app.tail.ts.net", "app", old, "tag:gw"),
+						testDevice("n2", "app-1.tail.ts.net", "app", old, "tag:gw"),
+					}})
+				case http.MethodDelete:
+					deleted = append(deleted, r.URL.Path)
+				}
+			})
+
+			route := &RouteConfig{Name: "app", Tags: []string{"tag:gw"}, StaleDevice: StaleDeviceConfig{Policy: tt.policy, OfflineAfter: time.Minute}}
+			err := handleStaleDevices(context.Background(), client, route, nil)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.policy != staleDevicePolicyOff, listed)
+			assert.Equal(t, tt.wantDeleted, deleted)
+		})
+	}
+}
+
+func TestHandleStaleDevices_ListFailure(t *testing.T) {
+	client := newFakeTailscaleAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	route := &RouteConfig{Name: "app", StaleDevice: StaleDeviceConfig{Policy: staleDevicePolicyWarn}}
+	assert.NoError(t, handleStaleDevices(context.Background(), client, route, nil))
+
+	// The fail policy does not start a route it could not check.
+	route.StaleDevice.Policy = staleDevicePolicyFail
+	assert.Error(t, handleStaleDevices(context.Background(), client, route, nil))
+}
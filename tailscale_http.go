@@ -55,9 +55,14 @@ type RouteServer struct {
 	Backend   string
 
 	config *Config
+	route  *RouteConfig
 	otel   *OpenTelemetry
 
-	echo *echo.Echo
+	echo          *echo.Echo
+	clientCertTLS *tls.Config // Client certificate verification for the TLS listener, nil when disabled
+	whoIsFn       whoIsFunc   // Overrides the tsnet WhoIs lookup, used by tests
+	tailnetDialFn dialFunc    // Overrides the tsnet Dial, used by tests
+	gate          *routeGate  // Admission control from the admin API, nil when unmanaged
 }
 
 // RouteProxy holds the pre-configured proxy for a route
@@ -66,28 +71,49 @@ type RouteProxy struct {
 	RouteName      string
 	BackendURL     string
 	RequestTimeout time.Duration
-	TargetURL      *url.URL // Pre-parsed target URL
+	TargetURL      *url.URL            // Pre-parsed target URL
+	Limiter        *concurrencyLimiter // Optional cap on in-flight backend requests
 }
 
 func NewRouteServer(routeName string, server *tsnet.Server, backend string, config *Config, otel *OpenTelemetry) (*RouteServer, error) {
-	rs := &RouteServer{
+	return newRouteServer(&RouteServer{
 		RouteName: routeName,
 		Server:    server,
 		Backend:   backend,
 		config:    config,
 		otel:      otel,
-	}
+	})
+}
 
-	err := rs.initEcho()
-	if err != nil {
+// newRouteServer initializes a route server whose route configuration may
+// already be resolved, as for routes added through the admin API
+func newRouteServer(rs *RouteServer) (*RouteServer, error) {
+	if err := rs.initEcho(); err != nil {
 		return nil, err
 	}
-
 	return rs, nil
 }
 
+// loadRouteConfig resolves the effective configuration for this route once
+func (rs *RouteServer) loadRouteConfig() error {
+	if rs.route != nil {
+		return nil
+	}
+	route, err := rs.config.RouteConfig(rs.RouteName)
+	if err != nil {
+		return err
+	}
+	rs.route = route
+	return nil
+}
+
 // newEcho creates a dedicated Echo instance for a single route
 func (rs *RouteServer) initEcho() error {
+	if err := rs.loadRouteConfig(); err != nil {
+		log.Error().Err(err).Str("route", rs.RouteName).Msg("Invalid route configuration")
+		return err
+	}
+
 	// Create Echo instance
 	e := echo.New()
 	e.HideBanner = true
@@ -113,6 +139,85 @@ func (rs *RouteServer) initEcho() error {
 		log.Info().Str("route", rs.RouteName).Msg("OpenTelemetry Echo middleware enabled")
 	}
 
+	// Paused and draining routes reject requests before any other work.
+	if rs.gate != nil {
+		e.Use(rs.gate.middleware)
+	}
+
+	if mode := rs.route.ClientCert.Mode; mode != "" && mode != clientCertAuthNone {
+		tlsCfg, err := newClientCertTLSConfig(rs.route.ClientCert)
+		if err != nil {
+			log.Error().Err(err).Str("route", rs.RouteName).Msg("Failed to configure client certificate authentication")
+			return err
+		}
+		rs.clientCertTLS = tlsCfg
+		e.Use(rs.clientCertMiddleware())
+		log.Info().Str("route", rs.RouteName).Str("mode", mode).Msg("Client certificate authentication enabled")
+	}
+
+	if rs.route.OIDC.Issuer != "" {
+		auth, err := newOIDCAuth(rs.RouteName, rs.route.OIDC)
+		if err != nil {
+			log.Error().Err(err).Str("route", rs.RouteName).Msg("Failed to configure OIDC authentication")
+			return err
+		}
+		e.Use(auth.middleware)
+		log.Info().Str("route", rs.RouteName).Str("issuer", rs.route.OIDC.Issuer).Msg("OIDC authentication enabled")
+	}
+
+	if rs.route.Auth.HtpasswdFile != "" || rs.route.Auth.APIKeysFile != "" {
+		auth, err := newCredentialAuth(rs)
+		if err != nil {
+			log.Error().Err(err).Str("route", rs.RouteName).Msg("Failed to configure credential authentication")
+			return err
+		}
+		e.Use(auth.middleware)
+		log.Info().Str("route", rs.RouteName).Bool("basic_auth", auth.users != nil).Bool("api_keys", auth.keys != nil).Strs("exempt_paths", rs.route.Auth.ExemptPaths).Strs("exempt_tags", rs.route.Auth.ExemptTags).Msg("Credential authentication enabled")
+	}
+
+	// Forward-auth runs before the cache so cached responses are authorized too.
+	if rs.route.ForwardAuth.URL != "" {
+		e.Use(newForwardAuth(rs, rs.otel.meter()).middleware)
+		log.Info().Str("route", rs.RouteName).Str("url", rs.route.ForwardAuth.URL).Dur("cache_ttl", rs.route.ForwardAuth.CacheTTL).Msg("Forward-auth enabled")
+	}
+
+	// The read timeout is enforced by the server; the middleware only tags the
+	// resulting body errors so they are answered with 408.
+	if limits := rs.route.Limits; limits.MaxBodySize > 0 || limits.MinTransferRate > 0 || limits.ReadTimeout > 0 {
+		e.Use(rs.requestLimitsMiddleware())
+		log.Info().Str("route", rs.RouteName).Int64("max_body_size", rs.route.Limits.MaxBodySize).Int64("min_transfer_rate", rs.route.Limits.MinTransferRate).Msg("Request body limits enabled")
+	}
+
+	if rs.route.RateLimit.Rate > 0 {
+		e.Use(rs.rateLimitMiddleware())
+		log.Info().Str("route", rs.RouteName).Float64("rate", rs.route.RateLimit.Rate).Str("key", rs.route.RateLimit.Key).Msg("Rate limiting enabled")
+	}
+
+	// Compression wraps the cache so cached bodies are stored uncompressed.
+	if rs.route.Compression.Enabled {
+		e.Use(newCompressor(rs.route.Compression).middleware)
+		log.Info().Str("route", rs.RouteName).Strs("encodings", rs.route.Compression.Encodings).Str("level", rs.route.Compression.Level).Msg("Response compression enabled")
+	}
+
+	if rs.route.Cache.Enabled {
+		cache, err := newHTTPCache(rs.RouteName, rs.route.Cache, rs.config.TsnetDir, rs.otel.meter())
+		if err != nil {
+			log.Error().Err(err).Str("route", rs.RouteName).Msg("Failed to create response cache")
+			return err
+		}
+		if mode := rs.route.ClientCert.Mode; mode != "" && mode != clientCertAuthNone {
+			cache.identityHeaders = append(cache.identityHeaders, headerClientCertSubject, headerClientCertFingerprint)
+		}
+		if rs.route.ForwardAuth.URL != "" {
+			cache.identityHeaders = append(cache.identityHeaders, rs.route.ForwardAuth.ResponseHeaders...)
+		}
+		e.Use(cache.middleware)
+		if len(rs.route.Cache.PurgeAllow) > 0 {
+			e.POST(cachePurgePath, cache.purgeHandler(rs.identify))
+		}
+		log.Info().Str("route", rs.RouteName).Int64("max_size", rs.route.Cache.MaxSize).Int64("disk_max_size", rs.route.Cache.DiskMaxSize).Msg("Response cache enabled")
+	}
+
 	// Create pre-configured proxy during initialization
 	routeProxy, err := rs.newRouteProxy()
 	if err != nil {
@@ -152,11 +257,45 @@ func (rs *RouteServer) newRouteProxy() (*RouteProxy, error) {
 		return nil, err
 	}
 
+	if err := rs.loadRouteConfig(); err != nil {
+		return nil, err
+	}
+
+	targets := []*url.URL{target}
+	for _, upstream := range rs.route.Upstreams {
+		u, err := url.Parse(upstream)
+		if err != nil {
+			log.Error().Err(err).Str("upstream", upstream).Msg("Failed to parse upstream URL")
+			return nil, err
+		}
+		targets = append(targets, u)
+	}
+
 	// Create reverse proxy
 	proxy := httputil.NewSingleHostReverseProxy(target)
-	proxy.Transport = rs.newProxyTransport(target)
+	transport, err := rs.newProxyTransport(targets)
+	if err != nil {
+		log.Error().Err(err).Str("route", rs.RouteName).Msg("Failed to configure proxy transport")
+		return nil, err
+	}
+	proxy.Transport = transport
+	if len(targets) > 1 || rs.route.Retry.Attempts > 0 {
+		proxy.Transport = newRetryTransport(proxy.Transport, rs.route.Retry, newUpstreamPool(targets), rs.RouteName)
+	}
 	proxy.BufferPool = newProxyBufferPool(32 * 1024)
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		// Failures reading the client body are the client's fault, not the backend's.
+		if status := clientErrorStatus(r, err); status != 0 {
+			log.Debug().
+				Err(err).
+				Str("route", rs.RouteName).
+				Str("method", r.Method).
+				Str("path", r.URL.Path).
+				Int("status", status).
+				Msg("Rejected client request body")
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
 		log.Warn().
 			Err(err).
 			Str("route", rs.RouteName).
@@ -167,23 +306,29 @@ func (rs *RouteServer) newRouteProxy() (*RouteProxy, error) {
 		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
 	}
 
-	log.Debug().Str("route", rs.RouteName).Str("backend", target.String()).Bool("skip_tls_verify", rs.config.SkipTLSVerify).Msg("Configured proxy transport")
+	log.Debug().Str("route", rs.RouteName).Str("backend", target.String()).Int("upstreams", len(targets)).Int("retry_attempts", rs.route.Retry.Attempts).Str("dial_via", rs.route.DialVia).Bool("skip_tls_verify", rs.route.UpstreamTLS.SkipVerify).Msg("Configured proxy transport")
 
-	return &RouteProxy{
+	routeProxy := &RouteProxy{
 		Proxy:          proxy,
 		RouteName:      rs.RouteName,
 		BackendURL:     rs.Backend,
 		RequestTimeout: rs.config.RequestTimeout,
 		TargetURL:      target,
-	}, nil
+	}
+	if rs.route.Concurrency.MaxInFlight > 0 {
+		routeProxy.Limiter = newConcurrencyLimiter(rs.RouteName, rs.route.Concurrency, rs.otel.meter())
+		log.Info().Str("route", rs.RouteName).Int("max_in_flight", rs.route.Concurrency.MaxInFlight).Int("max_queue", rs.route.Concurrency.MaxQueue).Msg("Backend concurrency limit enabled")
+	}
+
+	return routeProxy, nil
 }
 
-func (rs *RouteServer) newProxyTransport(target *url.URL) http.RoundTripper {
+func (rs *RouteServer) newProxyTransport(targets []*url.URL) (http.RoundTripper, error) {
 	// Clone the default transport so we keep sane defaults (proxy env vars, HTTP/2,
 	// dialer behavior, etc) while tuning pooling for reverse-proxy workloads.
 	base, ok := http.DefaultTransport.(*http.Transport)
 	if !ok {
-		return http.DefaultTransport
+		return http.DefaultTransport, nil
 	}
 
 	tr := base.Clone()
@@ -210,23 +355,49 @@ func (rs *RouteServer) newProxyTransport(target *url.URL) http.RoundTripper {
 		KeepAlive: 30 * time.Second,
 	}).DialContext
 
-	if target != nil && target.Scheme == "https" {
-		// Clone any existing TLS config rather than mutating shared pointers.
-		var tlsCfg *tls.Config
-		if tr.TLSClientConfig != nil {
-			tlsCfg = tr.TLSClientConfig.Clone()
-		} else {
-			tlsCfg = &tls.Config{}
+	// Backends only reachable through Tailscale are dialed from the route
+	// node. Proxy env vars are ignored since a proxy cannot reach them either.
+	if rs.route.DialVia == dialViaTailnet {
+		dial, err := rs.tailnetDial()
+		if err != nil {
+			return nil, err
+		}
+		tr.Proxy = nil
+		tr.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+			defer cancel()
+			return dial(ctx, network, address)
+		}
+	}
+
+	useTLS := false
+	for _, target := range targets {
+		useTLS = useTLS || (target != nil && target.Scheme == "https")
+	}
+	if useTLS {
+		// Build a fresh TLS config per route rather than mutating shared pointers.
+		tlsCfg, err := newUpstreamTLSConfig(rs.route.UpstreamTLS)
+		if err != nil {
+			return nil, err
 		}
-		tlsCfg.InsecureSkipVerify = rs.config.SkipTLSVerify
 		tr.TLSClientConfig = tlsCfg
 	}
 
-	return tr
+	return tr, nil
 }
 
 // handler serves a proxy request using a pre-configured proxy
 func (rp *RouteProxy) handler(c echo.Context) error {
+	// Wait for a free backend slot before the request timeout starts counting.
+	if rp.Limiter != nil {
+		release, err := rp.Limiter.acquire(c.Request().Context())
+		if err != nil {
+			log.Debug().Err(err).Str("route", rp.RouteName).Str("path", c.Request().URL.Path).Msg("Request rejected by concurrency limit")
+			return echo.ErrServiceUnavailable
+		}
+		defer release()
+	}
+
 	// Optional request timeout (0 disables; recommended for long-lived streams).
 	if rp.RequestTimeout > 0 {
 		ctx, cancel := context.WithTimeout(c.Request().Context(), rp.RequestTimeout)
@@ -250,25 +421,32 @@ func (rs *RouteServer) Start(ctx context.Context) error {
 	}
 	defer lnHTTP.Close()
 
-	lnHTTPS, err := rs.Server.ListenTLS("tcp", fmt.Sprintf(":%d", rs.config.HTTPSPort))
+	lnHTTPS, err := rs.listenTLS(fmt.Sprintf(":%d", rs.config.HTTPSPort))
 	if err != nil {
 		log.Error().Err(err).Str("route", rs.RouteName).Msg("Failed to listen on Tailscale TLS")
 		return fmt.Errorf("failed to listen on TLS for route %s: %w", rs.RouteName, err)
 	}
 	defer lnHTTPS.Close()
 
-	log.Info().Str("route", rs.RouteName).Str("fqdn", rs.RouteName+"."+rs.config.TailscaleDomain).Int("http-port", rs.config.HTTPPort).Int("https-port", rs.config.HTTPSPort).Msg("Tailscale servers listening for route")
+	log.Info().Str("route", rs.RouteName).Str("fqdn", rs.route.NodeHostname()+"."+rs.config.TailscaleDomain).Int("http-port", rs.config.HTTPPort).Int("https-port", rs.config.HTTPSPort).Msg("Tailscale servers listening for route")
 
 	// Keep separate server instances per listener (avoid calling Serve twice on the same http.Server).
+	limits := rs.route.Limits
 	httpsServer := &http.Server{
 		Handler:           rs.echo,
 		ReadHeaderTimeout: 10 * time.Second,
 		IdleTimeout:       2 * time.Minute,
+		ReadTimeout:       limits.ReadTimeout,
+		WriteTimeout:      limits.WriteTimeout,
+		MaxHeaderBytes:    limits.MaxHeaderBytes,
 	}
 	httpServer := &http.Server{
 		Handler:           rs.echo,
 		ReadHeaderTimeout: 10 * time.Second,
 		IdleTimeout:       2 * time.Minute,
+		ReadTimeout:       limits.ReadTimeout,
+		WriteTimeout:      limits.WriteTimeout,
+		MaxHeaderBytes:    limits.MaxHeaderBytes,
 	}
 
 	// Start server in a goroutine so we can listen for context cancellation
@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.opentelemetry.io/otel/metric/noop"
 	tracenoop "go.opentelemetry.io/otel/trace/noop"
+	"tailscale.com/client/tailscale/v2"
 )
 
 func TestCreateTailscaleClient(t *testing.T) {
@@ -204,6 +210,9 @@ func TestServer_StartRoute(t *testing.T) {
 				tsClient: tsClient,
 			}
 
+			// Keep route state out of the package directory.
+			tt.config.TsnetDir = t.TempDir()
+
 			// Test that startRoute handles the route appropriately
 			assert.NotPanics(t, func() {
 				err := s.startRoute(context.Background(), tt.routeName, tt.backendURL)
@@ -218,3 +227,46 @@ func TestServer_StartRoute(t *testing.T) {
 		})
 	}
 }
+
+// newFakeTailscaleAPI serves the Tailscale API paths used by tsgw and
+// records the requests it receives.
+func newFakeTailscaleAPI(t *testing.T, handler http.HandlerFunc) *tailscale.Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	baseURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return &tailscale.Client{BaseURL: baseURL, Tailnet: "-", HTTP: srv.Client()}
+}
+
+func TestCreateNewAuthKey_Ephemeral(t *testing.T) {
+	for _, ephemeral := range []bool{false, true} {
+		var body tailscale.CreateKeyRequest
+		client := newFakeTailscaleAPI(t, func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/api/v2/tailnet/-/keys", r.URL.Path)
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			_ = json.NewEncoder(w).Encode(map[string]string{"key": "tskey-auth-test"})
+		})
+
+		key, err := createNewAuthKey(context.Background(), client, []string{"tag:gw", "tag:prod-db"}, "app", ephemeral)
+		require.NoError(t, err)
+		assert.Equal(t, "tskey-auth-test", key)
+		assert.Equal(t, ephemeral, body.Capabilities.Devices.Create.Ephemeral)
+		assert.Equal(t, []string{"tag:gw", "tag:prod-db"}, body.Capabilities.Devices.Create.Tags)
+	}
+}
+
+func TestDeleteDevice(t *testing.T) {
+	var deleted string
+	client := newFakeTailscaleAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			deleted = r.URL.Path
+		}
+	})
+
+	deleteDevice(context.Background(), client, "app", "nABC123")
+	assert.Equal(t, "/api/v2/device/nABC123", deleted)
+
+	// A missing API client is not an error.
+	assert.NotPanics(t, func() { deleteDevice(context.Background(), nil, "app", "nABC123") })
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// normalizeCertPin turns a SHA-256 certificate fingerprint written as hex,
+// with or without colons, into lowercase hex.
+func normalizeCertPin(pin string) (string, error) {
+	p := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(pin), ":", ""))
+	b, err := hex.DecodeString(p)
+	if err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid certificate pin %q (expected a hex SHA-256 fingerprint)", pin)
+	}
+	return p, nil
+}
+
+func validateCertPins(pins []string) error {
+	for _, pin := range pins {
+		if _, err := normalizeCertPin(pin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newUpstreamTLSConfig builds the client TLS configuration used to reach the
+// backends of a route.
+func newUpstreamTLSConfig(cfg UpstreamTLSConfig) (*tls.Config, error) {
+	tlsCfg := &tls.Config{
+		InsecureSkipVerify: cfg.SkipVerify,
+		ServerName:         cfg.ServerName,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upstream CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in upstream CA file %s", cfg.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		return nil, errors.New("upstream client certificate and key must be set together")
+	}
+	if cfg.ClientCert != "" {
+		reloader, err := newCertReloader(cfg.ClientCert, cfg.ClientKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return reloader.get()
+		}
+	}
+
+	if len(cfg.PinSHA256) > 0 {
+		pins := make([]string, 0, len(cfg.PinSHA256))
+		for _, pin := range cfg.PinSHA256 {
+			p, err := normalizeCertPin(pin)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, p)
+		}
+		// Runs after chain verification (when enabled), so a pin narrows
+		// trust further; with verification skipped it is the only check.
+		tlsCfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("upstream presented no certificate")
+			}
+			sum := sha256.Sum256(cs.PeerCertificates[0].Raw)
+			if !slices.Contains(pins, hex.EncodeToString(sum[:])) {
+				return fmt.Errorf("upstream certificate for %s does not match any pinned fingerprint", cs.ServerName)
+			}
+			return nil
+		}
+	}
+
+	return tlsCfg, nil
+}
+
+// certReloader serves a client certificate and picks up a renewed pair from
+// disk, so short-lived certificates from an internal PKI keep working.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.get(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *certReloader) get() (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info, err := os.Stat(r.certFile)
+	if err != nil {
+		if r.cert != nil {
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("failed to stat upstream client certificate: %w", err)
+	}
+	if r.cert != nil && info.ModTime().Equal(r.modTime) {
+		return r.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		if r.cert != nil {
+			// Keep the previous pair while a renewal is half written.
+			return r.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load upstream client certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = info.ModTime()
+	return r.cert, nil
+}
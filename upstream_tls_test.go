@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestClientCert writes a self-signed client certificate and key and
+// returns their paths along with the certificate.
+func writeTestClientCert(t *testing.T) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tsgw"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile, cert
+}
+
+func writeServerCA(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600))
+	return caFile
+}
+
+func getWithTLS(t *testing.T, cfg UpstreamTLSConfig, url string) error {
+	t.Helper()
+	tlsCfg, err := newUpstreamTLSConfig(cfg)
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsCfg}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestUpstreamTLS_CustomCA(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	assert.Error(t, getWithTLS(t, UpstreamTLSConfig{}, srv.URL))
+	// The test certificate is issued for example.com.
+	assert.NoError(t, getWithTLS(t, UpstreamTLSConfig{CAFile: writeServerCA(t, srv), ServerName: "example.com"}, srv.URL))
+	assert.Error(t, getWithTLS(t, UpstreamTLSConfig{CAFile: writeServerCA(t, srv), ServerName: "other.internal"}, srv.URL))
+}
+
+func TestUpstreamTLS_Pinning(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	sum := sha256.Sum256(srv.Certificate().Raw)
+	pin := hex.EncodeToString(sum[:])
+
+	assert.NoError(t, getWithTLS(t, UpstreamTLSConfig{SkipVerify: true, PinSHA256: []string{pin}}, srv.URL))
+	assert.Error(t, getWithTLS(t, UpstreamTLSConfig{SkipVerify: true, PinSHA256: []string{hex.EncodeToString(make([]byte, 32))}}, srv.URL))
+}
+
+func TestUpstreamTLS_ClientCertificate(t *testing.T) {
+	certFile, keyFile, clientCert := writeTestClientCert(t)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := writeServerCA(t, srv)
+	assert.Error(t, getWithTLS(t, UpstreamTLSConfig{CAFile: caFile, ServerName: "example.com"}, srv.URL))
+	assert.NoError(t, getWithTLS(t, UpstreamTLSConfig{CAFile: caFile, ServerName: "example.com", ClientCert: certFile, ClientKey: keyFile}, srv.URL))
+}
+
+func TestNewUpstreamTLSConfig_Errors(t *testing.T) {
+	_, err := newUpstreamTLSConfig(UpstreamTLSConfig{CAFile: "/nonexistent/ca.pem"})
+	assert.Error(t, err)
+
+	_, err = newUpstreamTLSConfig(UpstreamTLSConfig{ClientCert: "client.crt"})
+	assert.Error(t, err)
+
+	_, err = newUpstreamTLSConfig(UpstreamTLSConfig{PinSHA256: []string{"not-hex"}})
+	assert.Error(t, err)
+}
+
+func TestNormalizeCertPin(t *testing.T) {
+	sum := sha256.Sum256([]byte("x"))
+	plain := hex.EncodeToString(sum[:])
+
+	var colons string
+	for i, b := range sum {
+		if i > 0 {
+			colons += ":"
+		}
+		colons += hex.EncodeToString([]byte{b})
+	}
+
+	got, err := normalizeCertPin(colons)
+	require.NoError(t, err)
+	assert.Equal(t, plain, got)
+
+	_, err = normalizeCertPin("abcd")
+	assert.Error(t, err)
+}
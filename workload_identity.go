@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+)
+
+// tokenExchangeURLPath is the Tailscale endpoint exchanging an OIDC ID token
+// from a trusted issuer for an API access token
+const tokenExchangeURLPath = "/api/v2/oauth/token-exchange"
+
+// validateWorkloadIdentity rejects an ID token file that would be ignored:
+// the exchange needs the OAuth client ID, and a self-hosted control server
+// has no Tailscale API to exchange it with.
+func validateWorkloadIdentity(cfg *Config) error {
+	if cfg.OAuth.IDTokenFile == "" {
+		return nil
+	}
+	if cfg.OAuth.ClientID == "" {
+		return errors.New("--oauth-id-token-file requires --oauth-client-id")
+	}
+	if cfg.KeyProvider.Provider == authKeyProviderHeadscale {
+		return errors.New("--oauth-id-token-file cannot be used with the headscale auth key provider")
+	}
+	return nil
+}
+
+// federatedTokenSource obtains Tailscale API access tokens through workload
+// identity federation. The ID token is read from its file on every exchange,
+// since projected service account tokens and CI tokens are rotated in place.
+type federatedTokenSource struct {
+	ctx         context.Context
+	clientID    string
+	tokenFile   string
+	exchangeURL string
+	client      *http.Client
+}
+
+func newFederatedTokenSource(ctx context.Context, clientID, tokenFile, exchangeURL string) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(nil, &federatedTokenSource{
+		ctx:         ctx,
+		clientID:    clientID,
+		tokenFile:   tokenFile,
+		exchangeURL: exchangeURL,
+		client:      &http.Client{Timeout: 30 * time.Second},
+	})
+}
+
+func (s *federatedTokenSource) Token() (*oauth2.Token, error) {
+	data, err := os.ReadFile(s.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ID token file: %w", err)
+	}
+	idToken := strings.TrimSpace(string(data))
+	if idToken == "" {
+		return nil, fmt.Errorf("ID token file %s is empty", s.tokenFile)
+	}
+
+	form := url.Values{"client_id": {s.clientID}, "jwt": {idToken}}
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, s.exchangeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange ID token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to exchange ID token: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse token exchange response: %w", err)
+	}
+	if result.AccessToken == "" {
+		return nil, errors.New("token exchange returned an empty access token")
+	}
+
+	token := &oauth2.Token{AccessToken: result.AccessToken, TokenType: result.TokenType}
+	if result.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	log.Debug().Time("expiry", token.Expiry).Msg("Obtained Tailscale API token through workload identity federation")
+	return token, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFederatedTokenSource(t *testing.T) {
+	var exchanged []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, tokenExchangeURLPath, r.URL.Path)
+		assert.Equal(t, "client-id", r.PostForm.Get("client_id"))
+		if r.PostForm.Get("jwt") == "expired-jwt" {
+			http.Error(w, "token expired", http.StatusUnauthorized)
+			return
+		}
+		exchanged = append(exchanged, r.PostForm.Get("jwt"))
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "api-token-" + r.PostForm.Get("jwt"),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(file, []byte("jwt-1\n"), 0o600))
+	source := &federatedTokenSource{
+		ctx:         context.Background(),
+		clientID:    "client-id",
+		tokenFile:   file,
+		exchangeURL: srv.URL + tokenExchangeURLPath,
+		client:      srv.Client(),
+	}
+
+	token, err := source.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "api-token-jwt-1", token.AccessToken)
+	assert.True(t, token.Valid())
+
+	// A rotated ID token is picked up on the next exchange.
+	require.NoError(t, os.WriteFile(file, []byte("jwt-2"), 0o600))
+	token, err = source.Token()
+	require.NoError(t, err)
+	assert.Equal(t, "api-token-jwt-2", token.AccessToken)
+	assert.Equal(t, []string{"jwt-1", "jwt-2"}, exchanged)
+
+	require.NoError(t, os.WriteFile(file, []byte("expired-jwt"), 0o600))
+	_, err = source.Token()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "token expired")
+
+	source.tokenFile = filepath.Join(t.TempDir(), "missing")
+	_, err = source.Token()
+	assert.Error(t, err)
+}
+
+func TestCreateTailscaleClient_WorkloadIdentity(t *testing.T) {
+	var authorization string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case tokenExchangeURLPath:
+			_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "api-token", "token_type": "Bearer", "expires_in": 3600})
+		default:
+			authorization = r.Header.Get("Authorization")
+			_ = json.NewEncoder(w).Encode(map[string]any{"devices": []any{}})
+		}
+	}))
+	defer srv.Close()
+
+	file := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(file, []byte("jwt"), 0o600))
+
+	client, err := createTailscaleClient(context.Background(), &Config{
+		OAuth: OAuthConfig{ClientID: "client-id", Issuer: srv.URL, IDTokenFile: file},
+	})
+	require.NoError(t, err)
+	_, err = client.Devices().List(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "Bearer api-token", authorization)
+}
+
+func TestValidateWorkloadIdentity(t *testing.T) {
+	assert.NoError(t, validateWorkloadIdentity(&Config{}))
+	assert.NoError(t, validateWorkloadIdentity(&Config{OAuth: OAuthConfig{ClientID: "client-id", IDTokenFile: "/token"}}))
+	assert.Error(t, validateWorkloadIdentity(&Config{OAuth: OAuthConfig{IDTokenFile: "/token"}}))
+	assert.Error(t, validateWorkloadIdentity(&Config{
+		OAuth:       OAuthConfig{ClientID: "client-id", IDTokenFile: "/token"},
+		KeyProvider: KeyProviderConfig{Provider: authKeyProviderHeadscale},
+	}))
+}